@@ -0,0 +1,178 @@
+package emitter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMaxInFlight is the default value of [Hub.MaxInFlight].
+const DefaultMaxInFlight = 64
+
+// DeliveryReport counts the listeners an event was delivered to, see [EmitResult.Report].
+// Listeners which filtered out the event are not counted.
+type DeliveryReport struct {
+	Delivered int // listeners which received the event
+	Failed    int // listeners which did not receive it before the context expired, or failed
+	Dropped   int // listeners skipped because their circuit breaker was open
+}
+
+// deliveryCounts is updated during emit when a report was requested
+type deliveryCounts struct {
+	delivered, failed, dropped atomic.Int64
+}
+
+func (c *deliveryCounts) add(delivered, failed, dropped int) {
+	if c == nil {
+		return
+	}
+	c.delivered.Add(int64(delivered))
+	c.failed.Add(int64(failed))
+	c.dropped.Add(int64(dropped))
+}
+
+// EmitResult is the future returned by [Hub.EmitAsync].
+type EmitResult struct {
+	hub    *Hub
+	ctx    context.Context
+	ev     *Event
+	bubble bool
+	counts deliveryCounts
+	done   chan struct{}
+	err    error
+}
+
+// Done returns a channel closed once the emit has completed.
+func (r *EmitResult) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns the error of the emit, as returned by [Hub.Emit]. It returns nil until the
+// emit has completed.
+func (r *EmitResult) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+// Wait waits for the emit to complete and returns its error.
+func (r *EmitResult) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// Report returns the number of listeners the event was delivered to so far, which is final
+// once the emit has completed.
+func (r *EmitResult) Report() DeliveryReport {
+	return DeliveryReport{
+		Delivered: int(r.counts.delivered.Load()),
+		Failed:    int(r.counts.failed.Load()),
+		Dropped:   int(r.counts.dropped.Load()),
+	}
+}
+
+func (r *EmitResult) run() {
+	defer close(r.done)
+
+	if err := r.ctx.Err(); err != nil {
+		// expired while queued
+		r.err = err
+		return
+	}
+	r.err = r.hub.emitEvent(r.ctx, r.ev, r.bubble)
+}
+
+// EmitAsync emits an event in the background and returns immediately. The returned
+// [EmitResult] allows waiting for completion and retrieving the error and delivery
+// report.
+//
+// Async emits of a given topic are performed one at a time, in the order EmitAsync was
+// called, and the number of emits running at the same time on a hub is limited by
+// [Hub.MaxInFlight]. Emits waiting for their turn are queued without limit.
+func (h *Hub) EmitAsync(ctx context.Context, topic string, args ...any) *EmitResult {
+	r := &EmitResult{
+		hub:    h,
+		ctx:    ctx,
+		bubble: h.Bubble,
+		done:   make(chan struct{}),
+	}
+	r.ev = &Event{
+		Context: ctx,
+		Topic:   h.name(topic),
+		Args:    args,
+		report:  &r.counts,
+	}
+
+	h.root().dispatcher().enqueue(r)
+	return r
+}
+
+// dispatcher runs async emits with a bounded number of goroutines, keeping emits of a
+// given topic in order
+type dispatcher struct {
+	max     int
+	lk      sync.Mutex
+	queues  map[string][]*EmitResult // pending emits of topics being dispatched
+	ready   []string                 // topics waiting for a worker
+	workers int
+}
+
+func (h *Hub) dispatcher() *dispatcher {
+	h.asyncOnce.Do(func() {
+		max := h.MaxInFlight
+		if max <= 0 {
+			max = DefaultMaxInFlight
+		}
+		h.async = &dispatcher{
+			max:    max,
+			queues: make(map[string][]*EmitResult),
+		}
+	})
+	return h.async
+}
+
+func (d *dispatcher) enqueue(r *EmitResult) {
+	topic := r.ev.Topic
+
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	q, ok := d.queues[topic]
+	d.queues[topic] = append(q, r)
+	if ok {
+		// the topic is already waiting for or running an emit
+		return
+	}
+	d.ready = append(d.ready, topic)
+	if d.workers < d.max {
+		d.workers += 1
+		go d.worker()
+	}
+}
+
+// worker runs one emit of each ready topic in turn, so a busy topic does not starve others
+func (d *dispatcher) worker() {
+	d.lk.Lock()
+	for len(d.ready) > 0 {
+		topic := d.ready[0]
+		d.ready = d.ready[1:]
+		r := d.queues[topic][0]
+		d.lk.Unlock()
+
+		r.run()
+
+		d.lk.Lock()
+		q := d.queues[topic][1:]
+		if len(q) == 0 {
+			delete(d.queues, topic)
+		} else {
+			d.queues[topic] = q
+			d.ready = append(d.ready, topic)
+		}
+	}
+	d.workers -= 1
+	d.lk.Unlock()
+}
@@ -0,0 +1,106 @@
+package emitter_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func TestEmitAsync(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	h.OnWithCap("a", 1)
+	h.OnWithCap("a", 1)
+
+	r := h.EmitAsync(context.Background(), "a", 42)
+	if err := r.Wait(); err != nil {
+		t.Fatalf("emit failed: %s", err)
+	}
+	if rep := r.Report(); rep.Delivered != 2 || rep.Failed != 0 {
+		t.Errorf("unexpected report %+v", rep)
+	}
+
+	if err := h.EmitAsync(context.Background(), "nobody").Wait(); err != emitter.ErrNoSuchTopic {
+		t.Errorf("expected no such topic, got %v", err)
+	}
+}
+
+func TestEmitAsyncTimeout(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	h.On("a") // never read
+	h.OnWithCap("a", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r := h.EmitAsync(ctx, "a")
+	if r.Err() != nil {
+		t.Errorf("Err must be nil before completion")
+	}
+
+	select {
+	case <-r.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("emit did not complete")
+	}
+	if !errors.Is(r.Err(), context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", r.Err())
+	}
+	if rep := r.Report(); rep.Delivered != 1 || rep.Failed != 1 {
+		t.Errorf("unexpected report %+v", rep)
+	}
+}
+
+func TestEmitAsyncOrder(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	ch := h.On("seq")
+	var last *emitter.EmitResult
+	for n := range 100 {
+		last = h.EmitAsync(context.Background(), "seq", n)
+	}
+	for n := range 100 {
+		ev := <-ch
+		if ev.Arg(0) != n {
+			t.Fatalf("expected event %d, got %v", n, ev.Arg(0))
+		}
+	}
+	if err := last.Wait(); err != nil {
+		t.Errorf("emit failed: %s", err)
+	}
+}
+
+func TestEmitAsyncMaxInFlight(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	h.MaxInFlight = 2
+
+	var chans []<-chan *emitter.Event
+	for n := range 3 {
+		chans = append(chans, h.On("t"+strconv.Itoa(n)))
+	}
+	for n := range 3 {
+		h.EmitAsync(context.Background(), "t"+strconv.Itoa(n))
+	}
+
+	// only two emits run, the third one waits for a worker
+	select {
+	case <-chans[2]:
+		t.Fatalf("third emit should not be running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for _, ch := range chans {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event not received")
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package emitter
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff returns the delay to wait before the given attempt, attempts being numbered
+// from 1. It is used for reconnections and retries.
+type Backoff func(attempt int) time.Duration
+
+// ExponentialBackoff returns a [Backoff] starting at base and doubling at each attempt,
+// up to max.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		d := base
+		for n := 1; n < attempt && d < max; n += 1 {
+			d *= 2
+		}
+		return min(d, max)
+	}
+}
+
+// ConstantBackoff returns a [Backoff] always waiting d.
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(int) time.Duration {
+		return d
+	}
+}
+
+// Jitter returns a [Backoff] randomizing the delays of b between half and the full
+// value, so that many clients failing at the same time do not retry simultaneously.
+func Jitter(b Backoff) Backoff {
+	return func(attempt int) time.Duration {
+		d := b(attempt)
+		if d <= 1 {
+			return d
+		}
+		return d/2 + rand.N(d/2+1)
+	}
+}
+
+// DefaultBackoff is an exponential backoff with jitter starting at 100ms and capped at 30s.
+var DefaultBackoff = Jitter(ExponentialBackoff(100*time.Millisecond, 30*time.Second))
@@ -0,0 +1,29 @@
+package emitter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := emitter.ExponentialBackoff(100*time.Millisecond, time.Second)
+
+	expect := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for n, e := range expect {
+		if d := b(n + 1); d != e*time.Millisecond {
+			t.Errorf("attempt %d: unexpected delay %s, expected %s", n+1, d, e*time.Millisecond)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	b := emitter.Jitter(emitter.ConstantBackoff(time.Second))
+
+	for i := 0; i < 100; i++ {
+		if d := b(1); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("delay %s out of range", d)
+		}
+	}
+}
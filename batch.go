@@ -0,0 +1,158 @@
+package emitter
+
+import "time"
+
+// Window is a set of events received during a time window, see [Hub.OnWindow].
+type Window struct {
+	Start  time.Time // included
+	End    time.Time // excluded
+	Events []*Event
+}
+
+// OnBatch returns a channel receiving the events of topic in batches of up to maxSize
+// events. A batch is sent once it is full, or maxWait after its first event was received.
+// If maxSize or maxWait is zero, batches are not limited in size or time respectively.
+//
+// The partial batch is sent when the listener is removed with [Hub.OffBatch] or the hub is
+// closed, then the channel is closed.
+func (h *Hub) OnBatch(topic string, maxSize int, maxWait time.Duration) <-chan []*Event {
+	in := h.On(topic)
+	out := make(chan []*Event)
+	h.root().batches.Store((<-chan []*Event)(out), in)
+
+	go func() {
+		defer h.root().batches.Delete((<-chan []*Event)(out))
+		defer close(out)
+
+		var batch []*Event
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		flush := func() {
+			if timer != nil {
+				timer.Stop()
+				timer, timeout = nil, nil
+			}
+			if len(batch) > 0 {
+				out <- batch
+				batch = nil
+			}
+		}
+
+		for {
+			select {
+			case ev, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, ev)
+				if len(batch) == 1 && maxWait > 0 {
+					timer = time.NewTimer(maxWait)
+					timeout = timer.C
+				}
+				if maxSize > 0 && len(batch) >= maxSize {
+					flush()
+				}
+			case <-timeout:
+				flush()
+			}
+		}
+	}()
+	return out
+}
+
+// OffBatch removes a listener created with [Hub.OnBatch]. The partial batch is sent,
+// and the channel is closed.
+func (h *Hub) OffBatch(ch <-chan []*Event) {
+	if in, ok := h.root().batches.Load(ch); ok {
+		h.Unsubscribe(in.(<-chan *Event))
+	}
+}
+
+// OnWindow returns a channel receiving the events of topic grouped by time windows of the
+// given size. A new window starts every slide: if slide is zero or equal to size, windows
+// are tumbling and each event belongs to a single window, if it is smaller, windows are
+// sliding and overlap. Windows are aligned on multiples of slide, and sent when they end.
+// Empty windows are not sent.
+//
+// The windows including the last received events are sent when the listener is removed
+// with [Hub.OffWindow] or the hub is closed, then the channel is closed. If size is zero
+// or negative, the returned channel is closed without listening to topic.
+func (h *Hub) OnWindow(topic string, size, slide time.Duration) <-chan Window {
+	if size <= 0 {
+		out := make(chan Window)
+		close(out)
+		return out
+	}
+	if slide <= 0 || slide > size {
+		slide = size
+	}
+
+	in := h.On(topic)
+	out := make(chan Window)
+	h.root().batches.Store((<-chan Window)(out), in)
+
+	go func() {
+		defer h.root().batches.Delete((<-chan Window)(out))
+		defer close(out)
+
+		type received struct {
+			ev *Event
+			at time.Time
+		}
+		var buf []received
+
+		send := func(end time.Time) {
+			start := end.Add(-size)
+			var evs []*Event
+			for _, r := range buf {
+				if !r.at.Before(start) && r.at.Before(end) {
+					evs = append(evs, r.ev)
+				}
+			}
+			if len(evs) > 0 {
+				out <- Window{Start: start, End: end, Events: evs}
+			}
+		}
+
+		next := time.Now().Truncate(slide).Add(slide)
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+
+		for {
+			select {
+			case ev, ok := <-in:
+				if !ok {
+					// send the windows containing the last events, which are still open
+					for end := next; len(buf) > 0 && !end.Add(-size).After(buf[len(buf)-1].at); end = end.Add(slide) {
+						send(end)
+					}
+					return
+				}
+				buf = append(buf, received{ev: ev, at: time.Now()})
+			case <-timer.C:
+				send(next)
+				next = next.Add(slide)
+
+				// forget events that will not be part of further windows
+				start := next.Add(-size)
+				n := 0
+				for n < len(buf) && buf[n].at.Before(start) {
+					n += 1
+				}
+				buf = buf[n:]
+
+				timer.Reset(time.Until(next))
+			}
+		}
+	}()
+	return out
+}
+
+// OffWindow removes a listener created with [Hub.OnWindow]. The windows including the
+// last received events are sent, and the channel is closed.
+func (h *Hub) OffWindow(ch <-chan Window) {
+	if in, ok := h.root().batches.Load(ch); ok {
+		h.Unsubscribe(in.(<-chan *Event))
+	}
+}
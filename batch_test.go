@@ -0,0 +1,161 @@
+package emitter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func receiveBatch(t *testing.T, ch <-chan []*emitter.Event) []*emitter.Event {
+	t.Helper()
+	select {
+	case b, ok := <-ch:
+		if !ok {
+			t.Fatalf("batch channel closed")
+		}
+		return b
+	case <-time.After(5 * time.Second):
+		t.Fatalf("batch not received")
+	}
+	return nil
+}
+
+func TestOnBatch(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	ctx := context.Background()
+
+	ch := h.OnBatch("metrics", 3, time.Hour)
+	go func() {
+		for n := range 7 {
+			h.Emit(ctx, "metrics", n)
+		}
+		h.OffBatch(ch)
+	}()
+
+	for n, size := range []int{3, 3, 1} {
+		b := receiveBatch(t, ch)
+		if len(b) != size {
+			t.Fatalf("expected batch of %d, got %d", size, len(b))
+		}
+		if b[0].Arg(0) != n*3 {
+			t.Errorf("unexpected first event %v", b[0].Arg(0))
+		}
+	}
+	if _, ok := <-ch; ok {
+		t.Errorf("channel was not closed")
+	}
+}
+
+func TestOnBatchWait(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	ch := h.OnBatch("metrics", 100, 20*time.Millisecond)
+	h.Emit(context.Background(), "metrics", 1)
+	h.Emit(context.Background(), "metrics", 2)
+
+	start := time.Now()
+	if b := receiveBatch(t, ch); len(b) != 2 {
+		t.Errorf("expected batch of 2, got %d", len(b))
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("batch was sent after %s", d)
+	}
+}
+
+func TestOnBatchClose(t *testing.T) {
+	h := emitter.New()
+
+	ch := h.OnBatch("metrics", 100, 0)
+	h.Emit(context.Background(), "metrics", 1)
+	h.Emit(context.Background(), "metrics", 2)
+	h.Close()
+
+	if b := receiveBatch(t, ch); len(b) != 2 {
+		t.Errorf("expected partial batch of 2, got %d", len(b))
+	}
+	if _, ok := <-ch; ok {
+		t.Errorf("channel was not closed")
+	}
+}
+
+func TestOnWindowTumbling(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	const size = 50 * time.Millisecond
+
+	ch := h.OnWindow("clicks", size, 0)
+	for n := range 3 {
+		h.Emit(context.Background(), "clicks", n)
+	}
+
+	// the events may be split over two windows
+	var cnt int
+	for cnt < 3 {
+		select {
+		case w := <-ch:
+			if w.End.Sub(w.Start) != size || w.Start.Truncate(size) != w.Start {
+				t.Errorf("unexpected window %s - %s", w.Start, w.End)
+			}
+			cnt += len(w.Events)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("window not received")
+		}
+	}
+	if cnt != 3 {
+		t.Errorf("expected 3 events, got %d", cnt)
+	}
+
+	// partial window sent on close
+	h.Emit(context.Background(), "clicks", 3)
+	h.OffWindow(ch)
+	var last emitter.Window
+	for w := range ch {
+		last = w
+	}
+	if len(last.Events) != 1 || last.Events[0].Arg(0) != 3 {
+		t.Errorf("unexpected last window %+v", last)
+	}
+}
+
+func TestOnWindowInvalid(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	for _, size := range []time.Duration{0, -time.Second} {
+		if _, ok := <-h.OnWindow("clicks", size, 0); ok {
+			t.Errorf("expected closed channel for size %s", size)
+		}
+	}
+	if err := h.EmitTimeout(time.Second, "clicks", 1); err != emitter.ErrNoSuchTopic {
+		t.Errorf("expected no listener, got %v", err)
+	}
+}
+
+func TestOnWindowSliding(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	ch := h.OnWindow("clicks", 100*time.Millisecond, 50*time.Millisecond)
+	h.Emit(context.Background(), "clicks", "x")
+
+	// the event belongs to two overlapping windows
+	var ends []time.Time
+	for range 2 {
+		select {
+		case w := <-ch:
+			if len(w.Events) != 1 || w.Events[0].Arg(0) != "x" {
+				t.Errorf("unexpected window events %v", w.Events)
+			}
+			ends = append(ends, w.End)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("window not received")
+		}
+	}
+	if d := ends[1].Sub(ends[0]); d != 50*time.Millisecond {
+		t.Errorf("expected windows to slide by 50ms, got %s", d)
+	}
+}
@@ -0,0 +1,224 @@
+package emitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default values of [BreakerPolicy].
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// BreakerTopic is the topic of the root hub on which [BreakerTransition] events are
+// emitted when the circuit breaker of a listener changes state.
+const BreakerTopic = "emitter/breaker"
+
+// ErrBreakerOpen is the reason given for events dead-lettered because the circuit breaker
+// of a listener was open, see [Hub.Breaker].
+var ErrBreakerOpen = errors.New("listener circuit breaker is open")
+
+// BreakerPolicy configures the circuit breakers of listeners, see [Hub.Breaker].
+type BreakerPolicy struct {
+	// Threshold is the number of consecutive delivery timeouts opening the breaker of a
+	// listener, and defaults to [DefaultBreakerThreshold].
+	Threshold int
+
+	// Cooldown is the time a breaker stays open before a single event is delivered to
+	// probe the listener, and defaults to [DefaultBreakerCooldown].
+	Cooldown time.Duration
+}
+
+// BreakerState is the state of the circuit breaker of a listener.
+type BreakerState int
+
+const (
+	// BreakerClosed is the normal state, events are delivered.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen means the listener timed out repeatedly, and events are dropped
+	// without waiting for it.
+	BreakerOpen
+
+	// BreakerHalfOpen means the cooldown has elapsed, and the next event is delivered to
+	// probe the listener. The breaker closes if it is received in time, and opens again
+	// otherwise.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// BreakerTransition is the argument of events emitted on [BreakerTopic].
+type BreakerTransition struct {
+	ListenerID uint64       // see [Hub.ListenerID]
+	Topic      string       // topic of the event causing the transition
+	From, To   BreakerState // previous and new states
+	Failures   int          // consecutive delivery timeouts
+}
+
+// ListenerStats contains information on a listener, see [Hub.ListenerStats].
+type ListenerStats struct {
+	ID       uint64
+	State    BreakerState
+	Failures int    // consecutive delivery timeouts
+	Dropped  uint64 // events dropped while the breaker was open
+
+	// Depth is the number of events waiting in the mailbox of listeners created with
+	// [Hub.OnPriority] or [Hub.OnElastic].
+	Depth int
+
+	// Spilled is the number of events currently written to disk, and SpilledEvents and
+	// SpilledBytes the total volume written to disk, see [ElasticOptions.Spill].
+	Spilled       int
+	SpilledEvents int64
+	SpilledBytes  int64
+}
+
+// breaker is the circuit breaker state of a listener
+type breaker struct {
+	lk       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool // an event is being delivered in half-open state
+	dropped  atomic.Uint64
+	notified chan struct{} // closed once the last transition has been emitted
+}
+
+// breakerAllow returns true if ev can be delivered to the listener
+func (t *topic) breakerAllow(p *BreakerPolicy, l *listener, ev *Event) bool {
+	b := &l.breaker
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		cooldown := p.Cooldown
+		if cooldown <= 0 {
+			cooldown = DefaultBreakerCooldown
+		}
+		if time.Since(b.openedAt) < cooldown {
+			break
+		}
+		t.breakerSet(l, ev, BreakerHalfOpen)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			break
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+	b.dropped.Add(1)
+	return false
+}
+
+// breakerDone records the result of the delivery of ev to l, err being nil if
+// the event was received
+func (t *topic) breakerDone(p *BreakerPolicy, l *listener, ev *Event, err error) {
+	b := &l.breaker
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		if b.state == BreakerHalfOpen {
+			t.breakerSet(l, ev, BreakerClosed)
+		}
+		return
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		// the emitter gave up, which says nothing about the listener
+		return
+	}
+
+	b.failures += 1
+	threshold := p.Threshold
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= threshold) {
+		b.openedAt = time.Now()
+		t.breakerSet(l, ev, BreakerOpen)
+	}
+}
+
+// breakerSet changes the state of the breaker of l and reports the transition in the
+// background, after the previous transitions. It is called with the breaker locked.
+func (t *topic) breakerSet(l *listener, ev *Event, state BreakerState) {
+	tr := &BreakerTransition{
+		ListenerID: l.id,
+		Topic:      ev.current(),
+		From:       l.breaker.state,
+		To:         state,
+		Failures:   l.breaker.failures,
+	}
+	l.breaker.state = state
+
+	// transitions of a listener are emitted in order
+	prev := l.breaker.notified
+	done := make(chan struct{})
+	l.breaker.notified = done
+
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), DeadLetterTimeout)
+		defer cancel()
+
+		ev := &Event{
+			Context: ctx,
+			Topic:   BreakerTopic,
+			Args:    []any{tr},
+		}
+		if err := t.hub.root().emitEvent(ctx, ev, false); err != nil && err != ErrNoSuchTopic {
+			t.hub.root().reportError(fmt.Errorf("failed to emit breaker transition of listener %d: %w", tr.ListenerID, err))
+		}
+	}()
+}
+
+// ListenerStats returns information on the listener receiving on ch, and false if ch is
+// not attached to any topic.
+func (h *Hub) ListenerStats(ch <-chan *Event) (ListenerStats, bool) {
+	l := h.findListener(ch)
+	if l == nil {
+		return ListenerStats{}, false
+	}
+
+	l.breaker.lk.Lock()
+	st := ListenerStats{
+		ID:       l.id,
+		State:    l.breaker.state,
+		Failures: l.breaker.failures,
+		Dropped:  l.breaker.dropped.Load(),
+	}
+	l.breaker.lk.Unlock()
+
+	if l.stats != nil {
+		l.stats(&st)
+	}
+	return st, true
+}
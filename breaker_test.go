@@ -0,0 +1,109 @@
+package emitter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func expectTransition(t *testing.T, ch <-chan *emitter.Event, id uint64, from, to emitter.BreakerState) {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		tr := ev.Arg(0).(*emitter.BreakerTransition)
+		if tr.ListenerID != id || tr.From != from || tr.To != to {
+			t.Errorf("expected transition %s -> %s of %d, got %+v", from, to, id, tr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("transition %s -> %s not received", from, to)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	h.Breaker = &emitter.BreakerPolicy{Threshold: 2, Cooldown: 100 * time.Millisecond}
+	transitions := h.OnWithCap(emitter.BreakerTopic, 10)
+
+	slow := h.On("feed") // not read yet
+	fast := h.OnWithCap("feed", 10)
+	id := h.ListenerID(slow)
+
+	for range 2 {
+		if err := h.EmitTimeout(10*time.Millisecond, "feed"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+	}
+	expectTransition(t, transitions, id, emitter.BreakerClosed, emitter.BreakerOpen)
+
+	// the open listener is skipped
+	if err := h.EmitTimeout(time.Second, "feed"); err != nil {
+		t.Fatalf("emit failed: %s", err)
+	}
+	if st, _ := h.ListenerStats(slow); st.State != emitter.BreakerOpen || st.Dropped != 1 || st.Failures != 2 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if st, _ := h.ListenerStats(fast); st.State != emitter.BreakerClosed || st.Dropped != 0 || st.Failures != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if len(fast) != 3 {
+		t.Errorf("expected 3 events on healthy listener, got %d", len(fast))
+	}
+
+	// after the cooldown, a successful probe closes the breaker
+	time.Sleep(100 * time.Millisecond)
+	go func() {
+		for range slow {
+		}
+	}()
+	if err := h.EmitTimeout(time.Second, "feed"); err != nil {
+		t.Fatalf("emit failed: %s", err)
+	}
+	expectTransition(t, transitions, id, emitter.BreakerOpen, emitter.BreakerHalfOpen)
+	expectTransition(t, transitions, id, emitter.BreakerHalfOpen, emitter.BreakerClosed)
+	if st, _ := h.ListenerStats(slow); st.State != emitter.BreakerClosed || st.Failures != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestBreakerProbeFailure(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	h.Breaker = &emitter.BreakerPolicy{Threshold: 1, Cooldown: 50 * time.Millisecond}
+	transitions := h.OnWithCap(emitter.BreakerTopic, 10)
+
+	h.SetDeadLetter("feed", "dlq")
+	q := h.DeadLetters("dlq", 0)
+	defer q.Close()
+
+	ch := h.On("feed")
+	id := h.ListenerID(ch)
+
+	h.EmitTimeout(10*time.Millisecond, "feed")
+	expectTransition(t, transitions, id, emitter.BreakerClosed, emitter.BreakerOpen)
+	h.EmitTimeout(10*time.Millisecond, "feed")
+
+	time.Sleep(50 * time.Millisecond)
+	if err := h.EmitTimeout(10*time.Millisecond, "feed"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected probe to time out, got %v", err)
+	}
+	expectTransition(t, transitions, id, emitter.BreakerOpen, emitter.BreakerHalfOpen)
+	expectTransition(t, transitions, id, emitter.BreakerHalfOpen, emitter.BreakerOpen)
+
+	// timed out and dropped events were dead-lettered
+	var timeouts, dropped int
+	for _, dl := range waitDeadLetters(t, q, 3) {
+		switch {
+		case errors.Is(dl.Reason, context.DeadlineExceeded):
+			timeouts += 1
+		case errors.Is(dl.Reason, emitter.ErrBreakerOpen):
+			dropped += 1
+		}
+	}
+	if timeouts != 2 || dropped != 1 {
+		t.Errorf("expected 2 timeouts and 1 dropped event, got %d and %d", timeouts, dropped)
+	}
+}
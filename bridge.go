@@ -0,0 +1,159 @@
+package emitter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// HeaderVia is the event header used by bridges to record the links an event went through,
+// as a comma separated list of link identifiers, the first one being the origin. It is used
+// to prevent events from looping between bridged hubs.
+const HeaderVia = "Emitter-Via"
+
+// MaxBridgeHops is the maximum number of links an event can go through.
+const MaxBridgeHops = 16
+
+// BridgeOptions configures a bridge created with [Bridge].
+type BridgeOptions struct {
+	// Topics lists the topics to forward. Patterns such as tenant/{tenant}/order
+	// can be used to forward all matching topics, see [Hub.OnPattern].
+	Topics []string
+
+	// Rename, if set, returns the name of the destination topic for a given source
+	// topic. Patterns are never renamed.
+	Rename func(string) string
+
+	// Filter, if set, is called for each event and only events for which it returns
+	// true are forwarded. As with [Hub.OnFilter], it must be fast.
+	Filter func(*Event) bool
+
+	// Bidirectional, if set, makes events also flow from the destination hub to the
+	// source hub, on the renamed topics.
+	Bidirectional bool
+}
+
+// Link is an active bridge between two hubs, as returned by [Bridge].
+type Link struct {
+	id     string
+	subs   []linkSub
+	closed atomic.Bool
+	once   sync.Once
+}
+
+type linkSub struct {
+	hub *Hub
+	ch  <-chan *Event
+}
+
+// Bridge forwards events emitted on src to dst, according to opts. Forwarding happens during
+// emit, using the emitter's context, so [Hub.Emit] on src does not return until the event has
+// been delivered on dst as well, and cancellation or deadlines propagate across the bridge.
+// Errors from dst are returned to the emitter, except [ErrNoSuchTopic].
+//
+// Each forwarded event is a copy of the original, with the link added to its [HeaderVia]
+// header. Events that already went through the link, or through more than [MaxBridgeHops]
+// links, are not forwarded, which prevents loops.
+//
+// Call [Link.Close] to stop forwarding.
+func Bridge(src, dst *Hub, opts BridgeOptions) (*Link, error) {
+	link := &Link{id: newLinkID()}
+
+	for _, topic := range opts.Topics {
+		forward, reverse := identity, identity
+		if opts.Rename != nil && !IsPattern(topic) {
+			orig, renamed := topic, opts.Rename(topic)
+			forward = func(string) string { return renamed }
+			reverse = func(string) string { return orig }
+		}
+
+		if err := link.forward(src, dst, topic, forward, opts.Filter); err != nil {
+			link.Close()
+			return nil, err
+		}
+		if !opts.Bidirectional {
+			continue
+		}
+		if err := link.forward(dst, src, forward(topic), reverse, opts.Filter); err != nil {
+			link.Close()
+			return nil, err
+		}
+	}
+	return link, nil
+}
+
+func (link *Link) forward(src, dst *Hub, topic string, rename func(string) string, filter func(*Event) bool) error {
+	l := newListener(0)
+	ch := l.ch
+	l.filter = func(ev *Event) bool {
+		if via := ev.Header[HeaderVia]; via != "" {
+			hops := strings.Split(via, ",")
+			if len(hops) >= MaxBridgeHops || slices.Contains(hops, link.id) {
+				return false
+			}
+		}
+		return filter == nil || filter(ev)
+	}
+	l.push = func(ctx context.Context, ev *Event) error {
+		if link.closed.Load() {
+			return nil
+		}
+		topic := dst.name(rename(src.relative(ev.Topic)))
+		err := dst.emitEvent(ctx, link.copyEvent(ev, topic), dst.Bubble)
+		if err == ErrNoSuchTopic {
+			return nil
+		}
+		return err
+	}
+
+	link.subs = append(link.subs, linkSub{hub: src, ch: ch})
+	return src.attach(topic, l, ch)
+}
+
+// copyEvent returns a copy of ev to be emitted on topic, with the link added to its path
+func (link *Link) copyEvent(ev *Event, topic string) *Event {
+	header := make(map[string]string, len(ev.Header)+1)
+	for k, v := range ev.Header {
+		header[k] = v
+	}
+	if via := header[HeaderVia]; via != "" {
+		header[HeaderVia] = via + "," + link.id
+	} else {
+		header[HeaderVia] = link.id
+	}
+
+	return &Event{
+		Context:  ev.Context,
+		Topic:    topic,
+		Args:     ev.Args,
+		Header:   header,
+		ID:       ev.ID,
+		Key:      ev.Key,
+		Priority: ev.Priority,
+	}
+}
+
+// Close stops forwarding events and removes all the link's subscriptions.
+func (link *Link) Close() error {
+	link.once.Do(func() {
+		link.closed.Store(true)
+		for _, sub := range link.subs {
+			sub.hub.Unsubscribe(sub.ch)
+		}
+	})
+	return nil
+}
+
+func identity(topic string) string {
+	return topic
+}
+
+func newLinkID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
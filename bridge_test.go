@@ -0,0 +1,125 @@
+package emitter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func TestBridge(t *testing.T) {
+	src := emitter.New()
+	dst := emitter.New()
+	dst.Cap = 10
+
+	link, err := emitter.Bridge(src, dst, emitter.BridgeOptions{
+		Topics: []string{"order", "user/{id}"},
+		Rename: func(topic string) string { return "remote/" + topic },
+		Filter: func(ev *emitter.Event) bool {
+			v, _ := emitter.Arg[string](ev, 0)
+			return v != "skip"
+		},
+	})
+	if err != nil {
+		t.Fatalf("Bridge failed: %v", err)
+	}
+
+	order := dst.On("remote/order")
+	user := dst.On("user/42")
+
+	for _, v := range []string{"skip", "data"} {
+		if err := src.EmitTimeout(time.Second, "order", v); err != nil {
+			t.Fatalf("Emit failed: %v", err)
+		}
+	}
+	if err := src.EmitTimeout(time.Second, "user/42", "data"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+
+	ev := <-order
+	if v, _ := emitter.Arg[string](ev, 0); v != "data" {
+		t.Errorf("unexpected value %s", v)
+	}
+	if ev.Header[emitter.HeaderVia] == "" {
+		t.Error("missing via header on forwarded event")
+	}
+	if ev := <-user; ev.Topic != "user/42" {
+		t.Errorf("unexpected topic %s", ev.Topic)
+	}
+
+	link.Close()
+
+	if err := src.EmitTimeout(time.Second, "order", "data"); err != nil && err != emitter.ErrNoSuchTopic {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	select {
+	case <-order:
+		t.Error("event forwarded after Close")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestBridgeBidirectional(t *testing.T) {
+	a := emitter.New()
+	b := emitter.New()
+	a.Cap = 10
+	b.Cap = 10
+
+	link, err := emitter.Bridge(a, b, emitter.BridgeOptions{
+		Topics:        []string{"chat"},
+		Rename:        func(topic string) string { return "a/" + topic },
+		Bidirectional: true,
+	})
+	if err != nil {
+		t.Fatalf("Bridge failed: %v", err)
+	}
+	defer link.Close()
+
+	onA := a.On("chat")
+	onB := b.On("a/chat")
+
+	if err := a.EmitTimeout(time.Second, "chat", "from a"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	if err := b.EmitTimeout(time.Second, "a/chat", "from b"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+
+	// each hub must receive both messages exactly once
+	for _, ch := range []<-chan *emitter.Event{onA, onB} {
+		for i := 0; i < 2; i++ {
+			select {
+			case <-ch:
+			case <-time.After(time.Second):
+				t.Fatal("timeout waiting for event")
+			}
+		}
+		select {
+		case ev := <-ch:
+			t.Errorf("unexpected looping event %v", ev.Args)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestBridgeContext(t *testing.T) {
+	src := emitter.New()
+	dst := emitter.New()
+
+	link, err := emitter.Bridge(src, dst, emitter.BridgeOptions{Topics: []string{"test"}})
+	if err != nil {
+		t.Fatalf("Bridge failed: %v", err)
+	}
+	defer link.Close()
+
+	// nobody reads this, so emit on src must time out
+	_ = dst.On("test")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := src.Emit(ctx, "test", "data"); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package emitter
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+)
+
+// EmitBubble emits an event on the given topic, then propagates it to parent topics, similar
+// to DOM event bubbling. An event emitted on a/b/c is delivered to the listeners of a/b/c, then
+// to the listeners of a/b, and finally to the listeners of a. At each level the event's
+// [Event.CurrentTopic] is set to the topic being delivered, while [Event.Topic] stays a/b/c.
+//
+// Propagation stops once [Event.StopPropagation] has been called. As events are delivered
+// through channels, a listener may still be processing the event while the next level is
+// delivered. Filters (see [Hub.OnFilter]) however run synchronously during emit and can
+// reliably stop propagation.
+//
+// When called on a namespace (see [Hub.Namespace]), propagation stops at the namespace's
+// top level topics. EmitBubble returns [ErrNoSuchTopic] only if none of the levels exist.
+func (h *Hub) EmitBubble(ctx context.Context, topic string, args ...any) error {
+	ev := &Event{
+		Context: ctx,
+		Topic:   h.name(topic),
+		Args:    args,
+	}
+
+	return h.emitBubble(ctx, ev)
+}
+
+func (h *Hub) emitBubble(ctx context.Context, ev *Event) error {
+	if ev.stopped == nil {
+		ev.stopped = new(atomic.Bool)
+	}
+
+	found := false
+	level := ev.Topic
+	for {
+		if t := h.getTopic(level, false); t != nil {
+			cur := ev
+			if found {
+				// ev has already been delivered, use a copy so CurrentTopic can be changed
+				cur = ev.clone()
+			}
+			cur.CurrentTopic = level
+			found = true
+
+			if err := t.emit(ctx, cur); err != nil {
+				return err
+			}
+			if ev.PropagationStopped() {
+				return nil
+			}
+		}
+
+		pos := strings.LastIndexByte(level, '/')
+		if pos == -1 || pos <= len(h.prefix) {
+			// do not propagate outside of the namespace
+			break
+		}
+		level = level[:pos]
+	}
+
+	if !found {
+		return ErrNoSuchTopic
+	}
+	return nil
+}
+
+// StopPropagation prevents the event from being delivered to further parent topics. It has
+// no effect on events that are not propagating, see [Hub.EmitBubble].
+func (ev *Event) StopPropagation() {
+	if ev.stopped != nil {
+		ev.stopped.Store(true)
+	}
+}
+
+// PropagationStopped returns true if [Event.StopPropagation] has been called.
+func (ev *Event) PropagationStopped() bool {
+	return ev.stopped != nil && ev.stopped.Load()
+}
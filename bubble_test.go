@@ -0,0 +1,80 @@
+package emitter_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func TestEmitBubble(t *testing.T) {
+	h := emitter.New()
+	h.Cap = 1
+
+	abc := h.On("a/b/c")
+	a := h.On("a")
+	other := h.On("a/x")
+
+	if err := h.EmitBubble(context.Background(), "a/b/c", "data"); err != nil {
+		t.Fatalf("EmitBubble failed: %v", err)
+	}
+
+	ev := <-abc
+	if ev.Topic != "a/b/c" || ev.CurrentTopic != "a/b/c" {
+		t.Errorf("unexpected topics %s / %s", ev.Topic, ev.CurrentTopic)
+	}
+	ev = <-a
+	if ev.Topic != "a/b/c" || ev.CurrentTopic != "a" {
+		t.Errorf("unexpected topics %s / %s", ev.Topic, ev.CurrentTopic)
+	}
+	select {
+	case <-other:
+		t.Error("event must not propagate to sibling topics")
+	default:
+	}
+}
+
+func TestEmitBubbleStopPropagation(t *testing.T) {
+	h := emitter.New()
+	h.Cap = 1
+
+	_ = h.OnFilter("a/b", func(ev *emitter.Event) bool {
+		ev.StopPropagation()
+		return true
+	})
+	a := h.On("a")
+
+	if err := h.EmitBubble(context.Background(), "a/b/c", "data"); err != nil {
+		t.Fatalf("EmitBubble failed: %v", err)
+	}
+
+	select {
+	case <-a:
+		t.Error("event propagated after StopPropagation")
+	default:
+	}
+}
+
+func TestEmitBubbleNoSuchTopic(t *testing.T) {
+	h := emitter.New()
+
+	if err := h.EmitBubble(context.Background(), "a/b/c"); err != emitter.ErrNoSuchTopic {
+		t.Errorf("expected ErrNoSuchTopic, got %v", err)
+	}
+}
+
+func TestHubBubble(t *testing.T) {
+	h := emitter.New()
+	h.Cap = 1
+	h.Bubble = true
+
+	a := h.On("a")
+
+	if err := h.Emit(context.Background(), "a/b", "data"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	ev := <-a
+	if ev.CurrentTopic != "a" {
+		t.Errorf("unexpected current topic %s", ev.CurrentTopic)
+	}
+}
@@ -0,0 +1,25 @@
+package emitter
+
+import "encoding/json"
+
+// Codec encodes and decodes values, typically event arguments, so that events can be
+// sent over the network or stored.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec is a [Codec] using encoding/json. Decoded arguments have the types
+// produced by encoding/json, such as float64 for numbers, and can be converted
+// with [Arg].
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
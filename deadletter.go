@@ -0,0 +1,263 @@
+package emitter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DeadLetterTimeout is the maximum time spent emitting a dead letter.
+	DeadLetterTimeout = 30 * time.Second
+
+	// DeadLetterQueueSize is the maximum number of dead letters waiting to be emitted on
+	// each dead-letter topic. Further dead letters are dropped, see [Hub.DeadLetterDrops].
+	DeadLetterQueueSize = 1000
+)
+
+// DeadLetter describes an event that could not be delivered to a listener. Dead letters
+// are emitted as the single argument of events on dead-letter topics, see [Hub.SetDeadLetter].
+type DeadLetter struct {
+	// Event is the event that could not be delivered.
+	Event *Event
+
+	// Topic is the topic the event was emitted on, which differs from Event.Topic when
+	// the event was propagating to parent topics.
+	Topic string
+
+	// Reason is the cause of the failure, such as [context.DeadlineExceeded] when the
+	// listener did not receive the event before the emit context expired.
+	Reason error
+
+	// ListenerID identifies the listener that did not receive the event, see
+	// [Hub.ListenerID]. It is zero if the topic had no listener at all.
+	ListenerID uint64
+
+	// Attempts is the number of times delivery of the event failed, including previous
+	// failures of re-driven events.
+	Attempts int
+}
+
+// SetDeadLetter routes the events emitted on topic that could not be delivered to one of its
+// listeners to the dlq topic, wrapped in a [DeadLetter]. An event is dead-lettered when the
+// emit context expires before a listener received it, when a listener filter panics, or when
+// the topic has no listener. Dead letters are emitted asynchronously, in order, and never
+// block the original emit. Failures to deliver them are reported through [Hub.OnError].
+//
+// The topic is created if needed. An empty dlq disables dead-lettering.
+func (h *Hub) SetDeadLetter(topic, dlq string) {
+	if dlq != "" {
+		dlq = h.name(dlq)
+	}
+	t := h.getTopic(h.name(topic), true)
+	t.listenersLk.Lock()
+	defer t.listenersLk.Unlock()
+	t.deadLetter = dlq
+}
+
+// deadLetter queues a dead letter for ev on dlq, if dlq is set
+func (h *Hub) deadLetter(dlq string, ev *Event, reason error, l *listener) {
+	if dlq == "" || ev.deadLetter {
+		// dead letters themselves are not dead-lettered, which could loop
+		return
+	}
+
+	topic := ev.current()
+	dl := &DeadLetter{
+		Event:    ev,
+		Topic:    topic,
+		Reason:   reason,
+		Attempts: ev.attempts + 1,
+	}
+	if l != nil {
+		dl.ListenerID = l.id
+	}
+
+	h = h.root()
+	h.dlqLk.Lock()
+	q, ok := h.dlq[dlq]
+	if !ok {
+		if h.dlq == nil {
+			h.dlq = make(map[string]chan *DeadLetter)
+		}
+		q = make(chan *DeadLetter, DeadLetterQueueSize)
+		h.dlq[dlq] = q
+		go h.emitDeadLetters(dlq, q)
+	}
+	select {
+	case q <- dl:
+		h.dlqLk.Unlock()
+	default:
+		if h.dlqDrops == nil {
+			h.dlqDrops = make(map[string]uint64)
+		}
+		h.dlqDrops[dlq] += 1
+		h.dlqLk.Unlock()
+		h.reportError(fmt.Errorf("dead letter queue %s is full, dropped dead letter for topic %s", dlq, topic))
+	}
+}
+
+// emitDeadLetters emits the dead letters of q on dlq until q is empty, then removes it.
+// There is at most one running for each dlq, so dead letters are emitted in order.
+func (h *Hub) emitDeadLetters(dlq string, q chan *DeadLetter) {
+	for {
+		select {
+		case dl := <-q:
+			h.emitDeadLetter(dlq, dl)
+			continue
+		default:
+		}
+
+		// dead letters are queued with dlqLk held, so q stays empty once removed
+		h.dlqLk.Lock()
+		if len(q) == 0 {
+			delete(h.dlq, dlq)
+			h.dlqLk.Unlock()
+			return
+		}
+		h.dlqLk.Unlock()
+	}
+}
+
+func (h *Hub) emitDeadLetter(dlq string, dl *DeadLetter) {
+	ctx, cancel := context.WithTimeout(context.Background(), DeadLetterTimeout)
+	defer cancel()
+
+	dev := &Event{
+		Context:    ctx,
+		Topic:      dlq,
+		Args:       []any{dl},
+		Header:     dl.Event.Header,
+		deadLetter: true,
+	}
+	if err := h.emitEvent(ctx, dev, false); err != nil {
+		h.reportError(fmt.Errorf("failed to emit dead letter for topic %s on %s: %w", dl.Topic, dlq, err))
+	}
+}
+
+// DeadLetterDrops returns the number of dead letters dropped because the queue of the dlq
+// topic was full, see [DeadLetterQueueSize].
+func (h *Hub) DeadLetterDrops(dlq string) uint64 {
+	r := h.root()
+	r.dlqLk.Lock()
+	defer r.dlqLk.Unlock()
+	return r.dlqDrops[h.name(dlq)]
+}
+
+// ListenerID returns the identifier of the listener receiving on ch, as found in
+// [DeadLetter.ListenerID], or zero if ch is not attached to any topic.
+func (h *Hub) ListenerID(ch <-chan *Event) uint64 {
+	if l := h.findListener(ch); l != nil {
+		return l.id
+	}
+	return 0
+}
+
+// Redrive emits the event of a dead letter again on its original topic. All the listeners
+// of the topic receive it, including those which already received it the first time. If
+// delivery fails again, the event is dead-lettered with an incremented attempt count.
+func (h *Hub) Redrive(ctx context.Context, dl *DeadLetter) error {
+	ev := &Event{
+		Context:  ctx,
+		Topic:    dl.Topic,
+		Args:     dl.Event.Args,
+		Header:   dl.Event.Header,
+		ID:       dl.Event.ID,
+		Key:      dl.Event.Key,
+		Priority: dl.Event.Priority,
+		attempts: dl.Attempts,
+	}
+	return h.root().emitEvent(ctx, ev, false)
+}
+
+// DeadLetterQueue keeps the dead letters received on a dead-letter topic so they can be
+// inspected and re-driven, see [Hub.DeadLetters].
+type DeadLetterQueue struct {
+	hub   *Hub
+	ch    <-chan *Event
+	max   int
+	lk    sync.Mutex
+	items []*DeadLetter
+}
+
+// DeadLetters subscribes to the dlq topic and keeps up to max received dead letters in
+// memory, dropping the oldest ones once full. If max is zero or negative, all dead letters
+// are kept.
+func (h *Hub) DeadLetters(dlq string, max int) *DeadLetterQueue {
+	q := &DeadLetterQueue{
+		hub: h,
+		ch:  h.OnWithCap(dlq, 16),
+		max: max,
+	}
+	go q.run()
+	return q
+}
+
+func (q *DeadLetterQueue) run() {
+	for ev := range q.ch {
+		dl, ok := ev.Arg(0).(*DeadLetter)
+		if !ok {
+			continue
+		}
+		q.lk.Lock()
+		q.items = append(q.items, dl)
+		if q.max > 0 && len(q.items) > q.max {
+			q.items = q.items[1:]
+		}
+		q.lk.Unlock()
+	}
+}
+
+// Len returns the number of dead letters in the queue.
+func (q *DeadLetterQueue) Len() int {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return len(q.items)
+}
+
+// List returns the dead letters in the queue, oldest first.
+func (q *DeadLetterQueue) List() []*DeadLetter {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return append([]*DeadLetter(nil), q.items...)
+}
+
+// Redrive re-emits the dead letters currently in the queue on their original topics, oldest
+// first, removing them from the queue. Events failing again are dead-lettered again by the
+// hub. Redrive stops at the first error and returns the number of re-driven events. If the
+// error is [ErrNoSuchTopic], the dead letter is kept in the queue.
+func (q *DeadLetterQueue) Redrive(ctx context.Context) (int, error) {
+	q.lk.Lock()
+	cnt := len(q.items)
+	q.lk.Unlock()
+
+	// only the dead letters present initially are re-driven, as failing events come back
+	for n := 0; n < cnt; n += 1 {
+		q.lk.Lock()
+		if len(q.items) == 0 {
+			q.lk.Unlock()
+			return n, nil
+		}
+		dl := q.items[0]
+		q.items = q.items[1:]
+		q.lk.Unlock()
+
+		if err := q.hub.Redrive(ctx, dl); err != nil {
+			if err == ErrNoSuchTopic {
+				q.lk.Lock()
+				q.items = append([]*DeadLetter{dl}, q.items...)
+				q.lk.Unlock()
+			}
+			return n, err
+		}
+	}
+	return cnt, nil
+}
+
+// Close unsubscribes from the dead-letter topic. The queue can still be inspected and
+// re-driven.
+func (q *DeadLetterQueue) Close() error {
+	q.hub.Unsubscribe(q.ch)
+	return nil
+}
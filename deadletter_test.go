@@ -0,0 +1,164 @@
+package emitter_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// waitDeadLetters waits until q holds n dead letters
+func waitDeadLetters(t *testing.T, q *emitter.DeadLetterQueue, n int) []*emitter.DeadLetter {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d dead letters, got %d", n, q.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return q.List()
+}
+
+func TestDeadLetterTimeout(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	h.SetDeadLetter("orders", "orders/dlq")
+	q := h.DeadLetters("orders/dlq", 0)
+	defer q.Close()
+
+	slow := h.On("orders") // never read
+	fast := h.OnWithCap("orders", 1)
+
+	if err := h.EmitTimeout(20*time.Millisecond, "orders", "o1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if ev := <-fast; ev.Arg(0) != "o1" {
+		t.Errorf("unexpected event %v", ev.Args)
+	}
+
+	dl := waitDeadLetters(t, q, 1)[0]
+	if dl.Topic != "orders" || dl.Event.Arg(0) != "o1" || dl.Attempts != 1 {
+		t.Errorf("unexpected dead letter %+v", dl)
+	}
+	if !errors.Is(dl.Reason, context.DeadlineExceeded) {
+		t.Errorf("unexpected reason %v", dl.Reason)
+	}
+	if id := h.ListenerID(slow); id == 0 || dl.ListenerID != id {
+		t.Errorf("expected listener %d, got %d", id, dl.ListenerID)
+	}
+
+	// redrive while nobody reads: the event fails again
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	if _, err := q.Redrive(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected redrive to time out, got %v", err)
+	}
+	cancel()
+	<-fast
+	if dl = waitDeadLetters(t, q, 1)[0]; dl.Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", dl.Attempts)
+	}
+
+	// redrive with a reading listener
+	go func() {
+		for range slow {
+		}
+	}()
+	go func() {
+		for range fast {
+		}
+	}()
+	if n, err := q.Redrive(context.Background()); n != 1 || err != nil {
+		t.Fatalf("redrive returned %d, %v", n, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if q.Len() != 0 {
+		t.Errorf("expected empty queue, got %d", q.Len())
+	}
+}
+
+func TestDeadLetterNoListener(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	h.SetDeadLetter("lonely", "dlq")
+	q := h.DeadLetters("dlq", 1)
+	defer q.Close()
+
+	for _, arg := range []string{"a", "b"} {
+		if err := h.Emit(context.Background(), "lonely", arg); err != nil {
+			t.Fatalf("emit failed: %s", err)
+		}
+		waitDeadLetters(t, q, 1)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	list := q.List()
+	if len(list) != 1 {
+		t.Fatalf("expected queue to be capped to 1, got %d", len(list))
+	}
+	dl := list[0]
+	if dl.Event.Arg(0) != "b" || dl.ListenerID != 0 || !errors.Is(dl.Reason, emitter.ErrNoSuchTopic) {
+		t.Errorf("unexpected dead letter %+v", dl)
+	}
+
+	// disabling dead-lettering
+	h.SetDeadLetter("lonely", "")
+	h.Emit(context.Background(), "lonely", "c")
+	time.Sleep(10 * time.Millisecond)
+	if q.List()[0].Event.Arg(0) != "b" {
+		t.Errorf("event was dead-lettered after disabling")
+	}
+}
+
+func TestDeadLetterFilterPanic(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	h.OnError = func(error) {}
+
+	h.SetDeadLetter("panic", "dlq")
+	q := h.DeadLetters("dlq", 0)
+	defer q.Close()
+
+	ch := h.OnFilter("panic", func(ev *emitter.Event) bool { panic("boom") })
+	h.Emit(context.Background(), "panic", 1)
+
+	dl := waitDeadLetters(t, q, 1)[0]
+	if !errors.Is(dl.Reason, emitter.ErrFilterPanic) || dl.ListenerID != h.ListenerID(ch) {
+		t.Errorf("unexpected dead letter %+v", dl)
+	}
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	var errs atomic.Int32
+	h.OnError = func(error) { errs.Add(1) }
+
+	// dead letters are emitted in order
+	h.SetDeadLetter("lonely", "dlq")
+	q := h.DeadLetters("dlq", 0)
+	defer q.Close()
+	for n := range 100 {
+		h.Emit(context.Background(), "lonely", n)
+	}
+	for n, dl := range waitDeadLetters(t, q, 100) {
+		if dl.Event.Arg(0) != n {
+			t.Fatalf("dead letter %d out of order: %v", n, dl.Event.Arg(0))
+		}
+	}
+
+	// dead letters are dropped once the queue is full
+	h.SetDeadLetter("full", "blocked")
+	h.On("blocked") // never read
+	for n := range emitter.DeadLetterQueueSize + 2 {
+		h.Emit(context.Background(), "full", n)
+	}
+	if n := h.DeadLetterDrops("blocked"); n == 0 || errs.Load() != int32(n) {
+		t.Errorf("unexpected %d drops with %d errors", n, errs.Load())
+	}
+}
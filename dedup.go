@@ -0,0 +1,192 @@
+package emitter
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDedupSize is the default number of identifiers remembered by [NewDedupStore].
+const DefaultDedupSize = 10000
+
+// DedupStore remembers the identifiers of events already emitted, see [Hub.SetDedup]. A
+// persistent implementation allows dropping duplicates across restarts.
+type DedupStore interface {
+	// Seen records id and returns true if it was already recorded.
+	Seen(id string) (bool, error)
+
+	// Forget removes id, so the event can be emitted again after a failed emit.
+	Forget(id string) error
+}
+
+// DedupOptions configures deduplication on a topic, see [Hub.SetDedup].
+type DedupOptions struct {
+	// Key returns the identifier of an event, [Event.ID] by default. Events with an empty
+	// identifier are never dropped.
+	Key func(*Event) string
+
+	// Store remembers identifiers. By default, a store created with [NewDedupStore] with
+	// the following Size and Window is used.
+	Store DedupStore
+
+	// Size is the number of identifiers remembered, [DefaultDedupSize] by default.
+	Size int
+
+	// Window, if set, is the duration during which identifiers are remembered.
+	Window time.Duration
+}
+
+// dedup is the deduplication state of a topic
+type dedup struct {
+	key   func(*Event) string
+	store DedupStore
+	hits  atomic.Uint64
+}
+
+// NewID returns a random identifier suitable for [Event.ID].
+func NewID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// SetDedup enables deduplication on topic: events whose identifier was already seen are
+// dropped before being delivered to listeners, and emit returns nil as if they had been
+// delivered. Identifiers of events whose emit failed are forgotten, so they can be retried.
+// Events re-driven with [Hub.Redrive] are not dropped. Passing nil options disables
+// deduplication.
+//
+// The topic is created if needed.
+func (h *Hub) SetDedup(topic string, opts *DedupOptions) {
+	var d *dedup
+	if opts != nil {
+		d = &dedup{key: opts.Key, store: opts.Store}
+		if d.key == nil {
+			d.key = func(ev *Event) string { return ev.ID }
+		}
+		if d.store == nil {
+			d.store = NewDedupStore(opts.Size, opts.Window)
+		}
+	}
+
+	t := h.getTopic(h.name(topic), true)
+	t.listenersLk.Lock()
+	defer t.listenersLk.Unlock()
+	t.dedup = d
+}
+
+// DedupHits returns the number of duplicate events dropped on topic since deduplication
+// was enabled.
+func (h *Hub) DedupHits(topic string) uint64 {
+	t := h.getTopic(h.name(topic), false)
+	if t == nil {
+		return 0
+	}
+	t.listenersLk.RLock()
+	defer t.listenersLk.RUnlock()
+	if t.dedup == nil {
+		return 0
+	}
+	return t.dedup.hits.Load()
+}
+
+// duplicate returns true if ev was already emitted on the topic and must be dropped.
+// Otherwise, it returns the function forgetting ev if its emit fails, if it was recorded.
+func (t *topic) duplicate(ev *Event) (bool, func()) {
+	t.listenersLk.RLock()
+	d := t.dedup
+	t.listenersLk.RUnlock()
+	if d == nil || ev.attempts > 0 {
+		// re-driven events are delivered again on purpose
+		return false, nil
+	}
+
+	id := d.key(ev)
+	if id == "" {
+		return false, nil
+	}
+	seen, err := d.store.Seen(id)
+	if err != nil {
+		// better deliver twice than lose the event
+		t.hub.reportError(fmt.Errorf("dedup store failed on topic %s: %w", ev.current(), err))
+		return false, nil
+	}
+	if seen {
+		d.hits.Add(1)
+		return true, nil
+	}
+	return false, func() {
+		if err := d.store.Forget(id); err != nil {
+			t.hub.reportError(fmt.Errorf("dedup store failed on topic %s: %w", ev.current(), err))
+		}
+	}
+}
+
+// memoryDedupStore is a [DedupStore] keeping identifiers in memory
+type memoryDedupStore struct {
+	size   int
+	window time.Duration
+	lk     sync.Mutex
+	order  *list.List // of *dedupEntry, oldest first
+	ids    map[string]*list.Element
+}
+
+type dedupEntry struct {
+	id string
+	at time.Time
+}
+
+// NewDedupStore returns a [DedupStore] remembering in memory the last size identifiers, or
+// [DefaultDedupSize] if size is zero or negative. If window is not zero, identifiers are
+// also forgotten once they were first seen for longer than window.
+func NewDedupStore(size int, window time.Duration) DedupStore {
+	if size <= 0 {
+		size = DefaultDedupSize
+	}
+	return &memoryDedupStore{
+		size:   size,
+		window: window,
+		order:  list.New(),
+		ids:    make(map[string]*list.Element),
+	}
+}
+
+func (s *memoryDedupStore) Seen(id string) (bool, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	now := time.Now()
+	if s.window > 0 {
+		for e := s.order.Front(); e != nil && now.Sub(e.Value.(*dedupEntry).at) >= s.window; e = s.order.Front() {
+			s.remove(e)
+		}
+	}
+	if _, ok := s.ids[id]; ok {
+		return true, nil
+	}
+
+	s.ids[id] = s.order.PushBack(&dedupEntry{id: id, at: now})
+	if s.order.Len() > s.size {
+		s.remove(s.order.Front())
+	}
+	return false, nil
+}
+
+func (s *memoryDedupStore) Forget(id string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if e, ok := s.ids[id]; ok {
+		s.remove(e)
+	}
+	return nil
+}
+
+func (s *memoryDedupStore) remove(e *list.Element) {
+	s.order.Remove(e)
+	delete(s.ids, e.Value.(*dedupEntry).id)
+}
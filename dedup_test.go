@@ -0,0 +1,178 @@
+package emitter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// emitIDs emits an event with each of the given IDs on topic and returns the received IDs
+func emitIDs(t *testing.T, h *emitter.Hub, topic string, ids ...string) []string {
+	t.Helper()
+	ch := h.OnWithCap(topic, uint(len(ids)))
+	defer h.Off(topic, ch)
+
+	for _, id := range ids {
+		ev := &emitter.Event{Context: context.Background(), ID: id}
+		if err := h.EmitEvent(context.Background(), topic, ev); err != nil {
+			t.Fatalf("emit failed: %s", err)
+		}
+	}
+	var res []string
+	for len(ch) > 0 {
+		res = append(res, (<-ch).ID)
+	}
+	return res
+}
+
+func TestDedup(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	h.SetDedup("orders", &emitter.DedupOptions{})
+	res := emitIDs(t, h, "orders", "a", "b", "a", "", "", "b")
+	if len(res) != 4 || res[0] != "a" || res[1] != "b" || res[2] != "" || res[3] != "" {
+		t.Errorf("unexpected events %q", res)
+	}
+	if n := h.DedupHits("orders"); n != 2 {
+		t.Errorf("expected 2 hits, got %d", n)
+	}
+
+	h.SetDedup("orders", nil)
+	if res := emitIDs(t, h, "orders", "a", "a"); len(res) != 2 {
+		t.Errorf("expected dedup to be disabled, got %q", res)
+	}
+}
+
+func TestDedupLimits(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	// a is forgotten once 2 other IDs were seen
+	h.SetDedup("size", &emitter.DedupOptions{Size: 2})
+	if res := emitIDs(t, h, "size", "a", "b", "c", "a", "c"); len(res) != 4 {
+		t.Errorf("unexpected events %q", res)
+	}
+
+	h.SetDedup("window", &emitter.DedupOptions{Window: 20 * time.Millisecond})
+	emitIDs(t, h, "window", "a")
+	if res := emitIDs(t, h, "window", "a"); len(res) != 0 {
+		t.Errorf("duplicate within window was delivered")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if res := emitIDs(t, h, "window", "a"); len(res) != 1 {
+		t.Errorf("event was dropped after window")
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Seen(string) (bool, error) { return false, errors.New("store down") }
+func (failingStore) Forget(string) error       { return errors.New("store down") }
+
+func TestDedupStore(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	var errs []error
+	h.OnError = func(err error) { errs = append(errs, err) }
+
+	// custom key
+	h.SetDedup("key", &emitter.DedupOptions{Key: func(ev *emitter.Event) string { return ev.Header["Idempotency-Key"] }})
+	ch := h.OnWithCap("key", 2)
+	for range 2 {
+		ev := &emitter.Event{Header: map[string]string{"Idempotency-Key": "k1"}}
+		h.EmitEvent(context.Background(), "key", ev)
+	}
+	if len(ch) != 1 {
+		t.Errorf("expected 1 event, got %d", len(ch))
+	}
+
+	// events are delivered when the store fails
+	h.SetDedup("failing", &emitter.DedupOptions{Store: failingStore{}})
+	if res := emitIDs(t, h, "failing", "a", "a"); len(res) != 2 {
+		t.Errorf("unexpected events %q", res)
+	}
+	if len(errs) != 2 {
+		t.Errorf("expected store errors to be reported, got %v", errs)
+	}
+}
+
+func TestDedupRetry(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	h.SetDedup("orders", &emitter.DedupOptions{})
+	ch := h.On("orders")
+
+	// nobody reads, so the emit times out
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := h.EmitEvent(ctx, "orders", &emitter.Event{ID: "o1"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// the retry is delivered
+	res := make(chan error, 1)
+	go func() { res <- h.EmitEvent(context.Background(), "orders", &emitter.Event{ID: "o1"}) }()
+	select {
+	case ev := <-ch:
+		if ev.ID != "o1" {
+			t.Errorf("unexpected ID %q", ev.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("retry was dropped")
+	}
+	if err := <-res; err != nil || h.DedupHits("orders") != 0 {
+		t.Errorf("unexpected retry %v with %d hits", err, h.DedupHits("orders"))
+	}
+}
+
+func TestDedupBridge(t *testing.T) {
+	a, b := emitter.New(), emitter.New()
+	defer a.Close()
+	defer b.Close()
+
+	link, err := emitter.Bridge(a, b, emitter.BridgeOptions{Topics: []string{"orders"}})
+	if err != nil {
+		t.Fatalf("bridge failed: %s", err)
+	}
+	defer link.Close()
+
+	// the ID is kept across the bridge
+	b.SetDedup("orders", &emitter.DedupOptions{})
+	ch := b.OnWithCap("orders", 2)
+	for range 2 {
+		a.EmitEvent(context.Background(), "orders", &emitter.Event{ID: "o1"})
+	}
+	if len(ch) != 1 || b.DedupHits("orders") != 1 {
+		t.Errorf("duplicate was not dropped")
+	}
+	if ev := <-ch; ev.ID != "o1" {
+		t.Errorf("unexpected ID %q", ev.ID)
+	}
+}
+
+func TestDedupRedrive(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	h.SetDedup("orders", &emitter.DedupOptions{})
+	h.SetDeadLetter("orders", "dlq")
+	q := h.DeadLetters("dlq", 0)
+	defer q.Close()
+
+	h.EmitEvent(context.Background(), "orders", &emitter.Event{ID: "o1"})
+	waitDeadLetters(t, q, 1)
+
+	// re-driven events are not considered duplicates
+	ch := h.OnWithCap("orders", 1)
+	if n, err := q.Redrive(context.Background()); n != 1 || err != nil {
+		t.Fatalf("redrive returned %d, %v", n, err)
+	}
+	if len(ch) != 1 {
+		t.Errorf("re-driven event was dropped")
+	}
+}
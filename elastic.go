@@ -0,0 +1,305 @@
+package emitter
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultElasticEvents is the default value of [ElasticOptions.MaxEvents].
+const DefaultElasticEvents = 4096
+
+// ElasticOptions configures a listener created with [Hub.OnElastic].
+type ElasticOptions struct {
+	// MaxEvents is the maximum number of events kept in memory, and defaults to
+	// [DefaultElasticEvents]. The buffer grows as needed up to this ceiling.
+	MaxEvents int
+
+	// Spill, if set, is used to encode the events that do not fit in memory to a
+	// temporary file. Otherwise, emitters wait for room once the ceiling is reached.
+	Spill Codec
+
+	// SpillDir is the directory of the temporary file, [os.TempDir] by default.
+	SpillDir string
+}
+
+// spilledEvent is the representation of an event written to disk
+type spilledEvent struct {
+	Topic        string            `json:"topic"`
+	CurrentTopic string            `json:"current_topic,omitempty"`
+	Args         []any             `json:"args,omitempty"`
+	Header       map[string]string `json:"header,omitempty"`
+	ID           string            `json:"id,omitempty"`
+	Key          string            `json:"key,omitempty"`
+	Priority     int               `json:"priority,omitempty"`
+}
+
+// OnElastic returns a channel receiving the events of topic through a buffer growing as
+// needed, up to [ElasticOptions.MaxEvents] events. Past this ceiling, events are written
+// to a temporary file if [ElasticOptions.Spill] is set, so emits never wait for this
+// listener. Spilled events are received in order once the events in memory have been
+// received, with their arguments decoded by the codec and a background context.
+//
+// The depth of the buffer and the spilled volume are available through
+// [Hub.ListenerStats]. Events still buffered when the listener is removed are discarded.
+func (h *Hub) OnElastic(topic string, opts ElasticOptions) <-chan *Event {
+	if opts.MaxEvents <= 0 {
+		opts.MaxEvents = DefaultElasticEvents
+	}
+
+	l := newListener(0)
+	m := &elasticMailbox{
+		hub:    h.root(),
+		opts:   opts,
+		out:    make(chan *Event),
+		done:   l.ch,
+		notify: make(chan struct{}, 1),
+		room:   make(chan struct{}, 1),
+	}
+	l.push = m.push
+	l.stats = m.stats
+
+	h.getTopic(h.name(topic), true).appendListener(l, m.out)
+	go m.pump()
+	return m.out
+}
+
+// ring is a FIFO of events growing as needed
+type ring struct {
+	buf  []*Event
+	head int
+	n    int
+}
+
+func (r *ring) push(ev *Event) {
+	if r.n == len(r.buf) {
+		buf := make([]*Event, max(16, 2*len(r.buf)))
+		for i := range r.n {
+			buf[i] = r.buf[(r.head+i)%len(r.buf)]
+		}
+		r.buf = buf
+		r.head = 0
+	}
+	r.buf[(r.head+r.n)%len(r.buf)] = ev
+	r.n += 1
+}
+
+func (r *ring) peek() *Event {
+	if r.n == 0 {
+		return nil
+	}
+	return r.buf[r.head]
+}
+
+func (r *ring) pop() {
+	r.buf[r.head] = nil
+	r.head = (r.head + 1) % len(r.buf)
+	r.n -= 1
+	if r.n == 0 && len(r.buf) > 64 {
+		// release memory after a burst
+		r.buf = nil
+		r.head = 0
+	}
+}
+
+type elasticMailbox struct {
+	hub    *Hub
+	opts   ElasticOptions
+	out    chan *Event
+	done   <-chan *Event
+	notify chan struct{} // signals the pump that an event was added
+	room   chan struct{} // signals emitters that an event was removed
+	lk     sync.Mutex
+	mem    ring
+	closed bool
+
+	file         *os.File
+	readOff      int64
+	writeOff     int64
+	onDisk       int   // number of events in the file
+	spilledBytes int64 // total bytes written to the file
+	spilledCnt   int64 // total events written to the file
+}
+
+func (m *elasticMailbox) push(ctx context.Context, ev *Event) error {
+	for {
+		m.lk.Lock()
+		if m.closed {
+			m.lk.Unlock()
+			return nil
+		}
+		if m.onDisk == 0 && m.mem.n < m.opts.MaxEvents {
+			m.mem.push(ev)
+			m.lk.Unlock()
+			signal(m.notify)
+			return nil
+		}
+		if m.opts.Spill != nil {
+			// once spilling, events go to disk until it is drained to keep them in order
+			err := m.spill(ev)
+			m.lk.Unlock()
+			signal(m.notify)
+			return err
+		}
+		m.lk.Unlock()
+
+		select {
+		case <-m.room:
+		case <-m.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// spill appends ev to the file, it is called with lk held
+func (m *elasticMailbox) spill(ev *Event) error {
+	buf, err := m.opts.Spill.Marshal(&spilledEvent{
+		Topic:        ev.Topic,
+		CurrentTopic: ev.CurrentTopic,
+		Args:         ev.Args,
+		Header:       ev.Header,
+		ID:           ev.ID,
+		Key:          ev.Key,
+		Priority:     ev.Priority,
+	})
+	if err != nil {
+		return err
+	}
+	if m.file == nil {
+		m.file, err = os.CreateTemp(m.opts.SpillDir, "emitter-spill-*")
+		if err != nil {
+			return err
+		}
+	}
+
+	rec := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(buf)), uint32(len(buf)))
+	rec = append(rec, buf...)
+	if _, err := m.file.WriteAt(rec, m.writeOff); err != nil {
+		return err
+	}
+	m.writeOff += int64(len(rec))
+	m.onDisk += 1
+	m.spilledBytes += int64(len(rec))
+	m.spilledCnt += 1
+	return nil
+}
+
+// load reads spilled events back in memory, it is called with lk held
+func (m *elasticMailbox) load() {
+	for m.onDisk > 0 && m.mem.n < m.opts.MaxEvents {
+		ev, err := m.readSpilled()
+		if err != nil {
+			m.hub.reportError(fmt.Errorf("failed to read spilled event: %w", err))
+			// the file cannot be trusted anymore
+			m.onDisk = 0
+			break
+		}
+		m.onDisk -= 1
+		if ev != nil {
+			m.mem.push(ev)
+		}
+	}
+	if m.onDisk == 0 && m.file != nil {
+		m.readOff, m.writeOff = 0, 0
+		m.file.Truncate(0)
+	}
+}
+
+// readSpilled returns the next event of the file, or nil if it could not be decoded
+func (m *elasticMailbox) readSpilled() (*Event, error) {
+	var hdr [4]byte
+	if _, err := m.file.ReadAt(hdr[:], m.readOff); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+	if _, err := m.file.ReadAt(buf, m.readOff+4); err != nil {
+		if err == io.EOF {
+			// the record is truncated
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	m.readOff += int64(4 + len(buf))
+
+	var rec spilledEvent
+	if err := m.opts.Spill.Unmarshal(buf, &rec); err != nil {
+		m.hub.reportError(fmt.Errorf("failed to decode spilled event: %w", err))
+		return nil, nil
+	}
+	return &Event{
+		Context:      context.Background(),
+		Topic:        rec.Topic,
+		CurrentTopic: rec.CurrentTopic,
+		Args:         rec.Args,
+		Header:       rec.Header,
+		ID:           rec.ID,
+		Key:          rec.Key,
+		Priority:     rec.Priority,
+	}, nil
+}
+
+// next returns the next event to be received, or nil if there is none
+func (m *elasticMailbox) next() *Event {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if m.mem.n == 0 && m.onDisk > 0 {
+		m.load()
+	}
+	return m.mem.peek()
+}
+
+func (m *elasticMailbox) pump() {
+	defer m.close()
+
+	for {
+		ev := m.next()
+		if ev == nil {
+			select {
+			case <-m.notify:
+				continue
+			case <-m.done:
+				return
+			}
+		}
+
+		select {
+		case m.out <- ev:
+			m.lk.Lock()
+			m.mem.pop()
+			m.lk.Unlock()
+			signal(m.room)
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *elasticMailbox) close() {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.closed = true
+	m.mem = ring{}
+	if m.file != nil {
+		m.file.Close()
+		os.Remove(m.file.Name())
+		m.file = nil
+	}
+	close(m.out)
+}
+
+func (m *elasticMailbox) stats(st *ListenerStats) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	st.Depth = m.mem.n + m.onDisk
+	st.Spilled = m.onDisk
+	st.SpilledEvents = m.spilledCnt
+	st.SpilledBytes = m.spilledBytes
+}
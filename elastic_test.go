@@ -0,0 +1,134 @@
+package emitter_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func TestElastic(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	ch := h.OnElastic("feed", emitter.ElasticOptions{MaxEvents: 1000})
+	for n := range 500 {
+		if err := h.EmitTimeout(time.Second, "feed", n); err != nil {
+			t.Fatalf("emit failed: %s", err)
+		}
+	}
+	if st, _ := h.ListenerStats(ch); st.Depth != 500 || st.Spilled != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	for n := range 500 {
+		if ev := <-ch; ev.Arg(0) != n {
+			t.Fatalf("expected %d, got %v", n, ev.Arg(0))
+		}
+	}
+}
+
+func TestElasticSpill(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	dir := t.TempDir()
+
+	ch := h.OnElastic("feed", emitter.ElasticOptions{MaxEvents: 10, Spill: emitter.JSONCodec, SpillDir: dir})
+	for n := range 100 {
+		if err := h.EmitTimeout(time.Second, "feed", n); err != nil {
+			t.Fatalf("emit failed: %s", err)
+		}
+	}
+	st, _ := h.ListenerStats(ch)
+	if st.Depth != 100 || st.Spilled != 90 || st.SpilledEvents != 90 || st.SpilledBytes == 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+
+	for n := range 100 {
+		ev := <-ch
+		if v, err := emitter.Arg[int](ev, 0); err != nil || v != n {
+			t.Fatalf("expected %d, got %v", n, ev.Arg(0))
+		}
+		if ev.Topic != "feed" {
+			t.Errorf("unexpected topic %s", ev.Topic)
+		}
+	}
+
+	// the last event is removed from the buffer once received
+	deadline := time.Now().Add(5 * time.Second)
+	for st, _ := h.ListenerStats(ch); st.Depth != 0 || st.SpilledEvents != 90; st, _ = h.ListenerStats(ch) {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected stats %+v", st)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the spill file is removed with the listener
+	h.Unsubscribe(ch)
+	for range ch {
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("spill file was not removed")
+	}
+}
+
+func TestElasticTruncated(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	errs := make(chan error, 1)
+	h.OnError = func(err error) { errs <- err }
+	dir := t.TempDir()
+
+	ch := h.OnElastic("feed", emitter.ElasticOptions{MaxEvents: 1, Spill: emitter.JSONCodec, SpillDir: dir})
+	for n := range 3 {
+		h.EmitTimeout(time.Second, "feed", n)
+	}
+
+	// a truncated record is reported instead of being decoded
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("expected a spill file, got %d files", len(files))
+	}
+	path := filepath.Join(dir, files[0].Name())
+	st, _ := os.Stat(path)
+	os.Truncate(path, st.Size()-1)
+
+	for n := range 2 {
+		if v, err := emitter.Arg[int](<-ch, 0); err != nil || v != n {
+			t.Fatalf("expected %d, got %v", n, v)
+		}
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("truncated record was not reported")
+	}
+	if st, _ := h.ListenerStats(ch); st.Depth != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestElasticFull(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	ch := h.OnElastic("feed", emitter.ElasticOptions{MaxEvents: 2})
+	for n := range 2 {
+		if err := h.EmitTimeout(time.Second, "feed", n); err != nil {
+			t.Fatalf("emit failed: %s", err)
+		}
+	}
+	if err := h.EmitTimeout(20*time.Millisecond, "feed", 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected emit to time out, got %v", err)
+	}
+	if ev := <-ch; ev.Arg(0) != 0 {
+		t.Errorf("unexpected event %v", ev.Arg(0))
+	}
+}
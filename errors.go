@@ -5,3 +5,15 @@ import "errors"
 // ErrNoSuchTopic is returned by [Hub.Emit] and [Hub.EmitEvent] when attempting
 // to emit an event to a topic that has no subscribers.
 var ErrNoSuchTopic = errors.New("no such topic")
+
+// ErrFilterPanic is reported through [Hub.OnError] when a listener filter panics
+// during emit. The event is not delivered to that listener.
+var ErrFilterPanic = errors.New("panic in listener filter")
+
+// ErrInvalidPattern is returned by [Hub.OnPattern] and [Router.Handle] when the given
+// topic pattern cannot be parsed.
+var ErrInvalidPattern = errors.New("invalid topic pattern")
+
+// ErrPatternCollision is returned by [Router.Handle] when a pattern matches exactly the
+// same topics as a pattern already registered on the router.
+var ErrPatternCollision = errors.New("topic pattern collision")
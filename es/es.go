@@ -0,0 +1,548 @@
+// Package es provides event sourcing on top of an [emitter.Hub], persisting events to an
+// append-only local file.
+//
+// Aggregates are rebuilt by replaying the events of their stream, and saved by appending
+// new events, with optimistic concurrency control:
+//
+//	store, err := es.Open("events.log", es.Options{Hub: hub, SnapshotEvery: 100})
+//	defer store.Close()
+//
+//	acct := &Account{}
+//	version, err := store.Load("account/42", acct)
+//	version, err = store.Save(ctx, "account/42", acct, version, es.Change{Type: "deposited", Data: 100})
+//
+// Appended events are emitted on the hub, where projections (see [Store.Project]) keep
+// read models up to date. Aggregates and projections can be snapshotted to speed up
+// replays.
+package es
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// DefaultTopic is the default topic on which appended events are emitted.
+const DefaultTopic = "es"
+
+// AnyVersion can be passed as the expected version of [Store.Append] to append
+// regardless of the current version of the stream.
+const AnyVersion = ^uint64(0)
+
+var (
+	// ErrConflict is returned when appending to a stream which is not at the expected
+	// version, typically because it was modified concurrently.
+	ErrConflict = errors.New("es: version conflict")
+
+	// ErrClosed is returned when using a closed store.
+	ErrClosed = errors.New("es: store closed")
+
+	// ErrNoHub is returned by [Store.Project] when the store has no hub.
+	ErrNoHub = errors.New("es: store has no hub")
+
+	// ErrCorrupt is returned when a record of the file does not match its checksum.
+	ErrCorrupt = errors.New("es: corrupt record")
+)
+
+// Options configures a store opened with [Open].
+type Options struct {
+	// Hub, if set, is the hub on which appended events are emitted.
+	Hub *emitter.Hub
+
+	// Topic is the topic on which appended events are emitted, [DefaultTopic] by default.
+	// Each event is emitted with the [*Event] as its only argument.
+	Topic string
+
+	// Codec encodes event data and snapshots, [emitter.JSONCodec] by default.
+	Codec emitter.Codec
+
+	// SnapshotEvery, if set, is the number of events after which aggregates are
+	// snapshotted by [Store.Load] and [Store.Save].
+	SnapshotEvery int
+
+	// NoSync disables syncing the file to disk after each write, which is faster but may
+	// lose the last events on power loss.
+	NoSync bool
+}
+
+// Change is an event to be appended to a stream.
+type Change struct {
+	Type string
+	Data any
+}
+
+// Event is an event stored in a stream.
+type Event struct {
+	Position uint64 // position in the store, starting at 1
+	Stream   string
+	Version  uint64 // position in the stream, starting at 1
+	Type     string
+	Data     []byte // data encoded with the codec of the store
+	Time     time.Time
+
+	codec emitter.Codec
+}
+
+// Decode decodes the data of the event into v.
+func (ev *Event) Decode(v any) error {
+	return ev.codec.Unmarshal(ev.Data, v)
+}
+
+// Aggregate is the state of a stream, rebuilt by applying its events in order. To be
+// snapshotted, aggregates must be encodable with the codec of the store.
+type Aggregate interface {
+	Apply(ev *Event) error
+}
+
+// kinds of records
+const (
+	kindEvents     = "e"
+	kindSnapshot   = "s"
+	kindProjection = "p"
+)
+
+// record is the unit written to the file. Events appended together share a record, so
+// they are either all stored or not at all.
+type record struct {
+	Kind    string        `json:"kind"`
+	Stream  string        `json:"stream"`           // stream, or projection name
+	Version uint64        `json:"version"`          // version of the first event, or of the snapshot
+	Time    time.Time     `json:"time"`             // time of the events
+	Events  []recordEvent `json:"events,omitempty"` // events
+	State   []byte        `json:"state,omitempty"`  // encoded snapshot
+}
+
+type recordEvent struct {
+	Type string `json:"type"`
+	Data []byte `json:"data,omitempty"`
+}
+
+// event returns the i-th event of the record, at the given position in the store
+func (rec *record) event(i int, pos uint64, codec emitter.Codec) *Event {
+	return &Event{
+		Position: pos,
+		Stream:   rec.Stream,
+		Version:  rec.Version + uint64(i),
+		Type:     rec.Events[i].Type,
+		Data:     rec.Events[i].Data,
+		Time:     rec.Time,
+		codec:    codec,
+	}
+}
+
+// eventRef locates an event in the file
+type eventRef struct {
+	off int64 // offset of the record
+	idx int   // index in the record
+}
+
+// snapshotRef locates the latest snapshot of an aggregate or projection
+type snapshotRef struct {
+	off     int64
+	version uint64
+}
+
+// Store is an event store persisted to an append-only file, see [Open].
+type Store struct {
+	opts   Options
+	file   *os.File
+	lk     sync.RWMutex
+	turn   chan struct{} // closed once the events of the last append were emitted
+	size   int64
+	closed bool
+
+	events      []eventRef             // by position - 1
+	streams     map[string][]uint64    // positions of the events of each stream
+	snapshots   map[string]snapshotRef // by stream
+	projections map[string]snapshotRef // by projection name
+}
+
+// Open opens the store persisted at path, creating it if needed, and indexes its events.
+// A record partially written when the process stopped is discarded, but other damaged
+// records make Open fail with [ErrCorrupt].
+func Open(path string, opts Options) (*Store, error) {
+	if opts.Topic == "" {
+		opts.Topic = DefaultTopic
+	}
+	if opts.Codec == nil {
+		opts.Codec = emitter.JSONCodec
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		opts:        opts,
+		file:        f,
+		turn:        make(chan struct{}),
+		streams:     make(map[string][]uint64),
+		snapshots:   make(map[string]snapshotRef),
+		projections: make(map[string]snapshotRef),
+	}
+	if err := s.scan(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	close(s.turn)
+	return s, nil
+}
+
+// errTorn is returned when reading a record which was not fully written
+var errTorn = errors.New("es: torn record")
+
+// scan indexes the records of the file
+func (s *Store) scan() error {
+	st, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var off int64
+	for {
+		rec, n, err := s.readRecord(off, st.Size())
+		if err == io.EOF {
+			break
+		}
+		if err == errTorn {
+			if err := s.file.Truncate(off); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		s.index(rec, off)
+		off += n
+	}
+	s.size = off
+	return nil
+}
+
+// readRecord reads the record at off, which must end before limit, and returns it with
+// its size
+func (s *Store) readRecord(off, limit int64) (*record, int64, error) {
+	var hdr [8]byte
+	if n, err := s.file.ReadAt(hdr[:], off); err != nil {
+		if n == 0 && err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		if err == io.EOF {
+			return nil, 0, errTorn
+		}
+		return nil, 0, err
+	}
+	n := int64(binary.BigEndian.Uint32(hdr[:4]))
+	if off+8+n > limit {
+		return nil, 0, errTorn
+	}
+	buf := make([]byte, n)
+	if _, err := s.file.ReadAt(buf, off+8); err != nil {
+		if err == io.EOF {
+			return nil, 0, errTorn
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(buf) != binary.BigEndian.Uint32(hdr[4:]) {
+		if off+8+n == limit {
+			// the last record may have been allocated but not fully written
+			return nil, 0, errTorn
+		}
+		return nil, 0, fmt.Errorf("%w at offset %d", ErrCorrupt, off)
+	}
+
+	rec := &record{}
+	if err := s.opts.Codec.Unmarshal(buf, rec); err != nil {
+		return nil, 0, fmt.Errorf("es: failed to decode record at offset %d: %w", off, err)
+	}
+	return rec, int64(8 + len(buf)), nil
+}
+
+// index adds the record written at off to the index, it is called with lk held
+func (s *Store) index(rec *record, off int64) {
+	switch rec.Kind {
+	case kindEvents:
+		for i := range rec.Events {
+			s.events = append(s.events, eventRef{off: off, idx: i})
+			s.streams[rec.Stream] = append(s.streams[rec.Stream], uint64(len(s.events)))
+		}
+	case kindSnapshot:
+		s.snapshots[rec.Stream] = snapshotRef{off: off, version: rec.Version}
+	case kindProjection:
+		s.projections[rec.Stream] = snapshotRef{off: off, version: rec.Version}
+	}
+}
+
+// write appends rec to the file and indexes it, it is called with lk held
+func (s *Store) write(rec *record) error {
+	if s.closed {
+		return ErrClosed
+	}
+	buf, err := s.opts.Codec.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	hdr := make([]byte, 8, 8+len(buf))
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(buf)))
+	binary.BigEndian.PutUint32(hdr[4:], crc32.ChecksumIEEE(buf))
+
+	if _, err := s.file.WriteAt(append(hdr, buf...), s.size); err != nil {
+		// do not leave a partial record behind
+		s.file.Truncate(s.size)
+		return err
+	}
+	if !s.opts.NoSync {
+		if err := s.file.Sync(); err != nil {
+			s.file.Truncate(s.size)
+			return err
+		}
+	}
+	s.index(rec, s.size)
+	s.size += int64(8 + len(buf))
+	return nil
+}
+
+// Append appends changes to stream if it is at the expected version, or returns
+// [ErrConflict]. Pass [AnyVersion] to append regardless of the version. The changes are
+// stored atomically, then emitted on the hub of the store, if any.
+//
+// The events are stored even if they could not be emitted before ctx expired. Projections
+// then retrieve them from the store.
+func (s *Store) Append(ctx context.Context, stream string, expected uint64, changes ...Change) ([]*Event, error) {
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rec := &record{Kind: kindEvents, Stream: stream, Time: time.Now()}
+	for _, c := range changes {
+		data, err := s.opts.Codec.Marshal(c.Data)
+		if err != nil {
+			return nil, err
+		}
+		rec.Events = append(rec.Events, recordEvent{Type: c.Type, Data: data})
+	}
+
+	s.lk.Lock()
+	version := uint64(len(s.streams[stream]))
+	if expected != AnyVersion && expected != version {
+		s.lk.Unlock()
+		return nil, fmt.Errorf("%w: stream %s is at version %d, expected %d", ErrConflict, stream, version, expected)
+	}
+	rec.Version = version + 1
+	pos := uint64(len(s.events)) + 1
+	if err := s.write(rec); err != nil {
+		s.lk.Unlock()
+		return nil, err
+	}
+
+	events := make([]*Event, len(rec.Events))
+	for i := range rec.Events {
+		events[i] = rec.event(i, pos+uint64(i), s.opts.Codec)
+	}
+
+	// emit in order, without preventing other appends meanwhile
+	prev, turn := s.turn, make(chan struct{})
+	s.turn = turn
+	s.lk.Unlock()
+	defer close(turn)
+	<-prev
+
+	if s.opts.Hub != nil {
+		for _, ev := range events {
+			s.opts.Hub.EmitEvent(ctx, s.opts.Topic, &emitter.Event{
+				Args: []any{ev},
+				ID:   ev.Stream + "@" + strconv.FormatUint(ev.Version, 10),
+			})
+		}
+	}
+	return events, nil
+}
+
+// Position returns the position of the last event of the store.
+func (s *Store) Position() uint64 {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	return uint64(len(s.events))
+}
+
+// Version returns the version of stream, which is 0 if it has no event.
+func (s *Store) Version(stream string) uint64 {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	return uint64(len(s.streams[stream]))
+}
+
+// reader reads events, keeping the last record read since consecutive events often share it
+type reader struct {
+	s   *Store
+	off int64
+	rec *record
+}
+
+func (r *reader) event(refs []eventRef, pos uint64) (*Event, error) {
+	ref := refs[pos-1]
+	if r.rec == nil || r.off != ref.off {
+		rec, _, err := r.s.readRecord(ref.off, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		r.rec, r.off = rec, ref.off
+	}
+	return r.rec.event(ref.idx, pos, r.s.opts.Codec), nil
+}
+
+// Replay calls fn with the events of the store following position after, in order, and
+// stops at the first error.
+func (s *Store) Replay(after uint64, fn func(*Event) error) error {
+	s.lk.RLock()
+	refs := s.events
+	s.lk.RUnlock()
+
+	r := reader{s: s}
+	for pos := after + 1; pos <= uint64(len(refs)); pos++ {
+		ev, err := r.event(refs, pos)
+		if err != nil {
+			return err
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplayStream calls fn with the events of stream following version after, in order,
+// and stops at the first error.
+func (s *Store) ReplayStream(stream string, after uint64, fn func(*Event) error) error {
+	s.lk.RLock()
+	refs := s.events
+	positions := s.streams[stream]
+	s.lk.RUnlock()
+
+	r := reader{s: s}
+	for _, pos := range positions[min(after, uint64(len(positions))):] {
+		ev, err := r.event(refs, pos)
+		if err != nil {
+			return err
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load rebuilds agg from the latest snapshot of stream, if any, and the events that
+// followed it, and returns the version of the stream.
+func (s *Store) Load(stream string, agg Aggregate) (uint64, error) {
+	s.lk.RLock()
+	snap, ok := s.snapshots[stream]
+	s.lk.RUnlock()
+
+	var version uint64
+	if ok {
+		if err := s.restore(snap, agg); err != nil {
+			return 0, fmt.Errorf("es: failed to restore snapshot of %s: %w", stream, err)
+		}
+		version = snap.version
+	}
+
+	err := s.ReplayStream(stream, version, func(ev *Event) error {
+		if err := agg.Apply(ev); err != nil {
+			return err
+		}
+		version = ev.Version
+		return nil
+	})
+	if err != nil {
+		return version, err
+	}
+	s.autoSnapshot(stream, agg, version)
+	return version, nil
+}
+
+// Save appends changes to stream if it is still at version, as returned by [Store.Load],
+// and applies the resulting events to agg. It returns the new version of the stream.
+func (s *Store) Save(ctx context.Context, stream string, agg Aggregate, version uint64, changes ...Change) (uint64, error) {
+	events, err := s.Append(ctx, stream, version, changes...)
+	if err != nil {
+		return version, err
+	}
+	for _, ev := range events {
+		if err := agg.Apply(ev); err != nil {
+			return version, err
+		}
+		version = ev.Version
+	}
+	s.autoSnapshot(stream, agg, version)
+	return version, nil
+}
+
+// Snapshot stores the state of agg at the given version of stream, so that [Store.Load]
+// only replays the events that followed.
+func (s *Store) Snapshot(stream string, agg Aggregate, version uint64) error {
+	return s.saveSnapshot(kindSnapshot, stream, agg, version)
+}
+
+// autoSnapshot snapshots agg if enough events were applied since the latest snapshot
+func (s *Store) autoSnapshot(stream string, agg Aggregate, version uint64) {
+	if s.opts.SnapshotEvery <= 0 {
+		return
+	}
+	s.lk.RLock()
+	last := s.snapshots[stream].version
+	s.lk.RUnlock()
+	if version >= last+uint64(s.opts.SnapshotEvery) {
+		// snapshots are only an optimization, failing to save one is not an error
+		s.Snapshot(stream, agg, version)
+	}
+}
+
+func (s *Store) saveSnapshot(kind, name string, v any, version uint64) error {
+	state, err := s.opts.Codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if kind == kindSnapshot && version > uint64(len(s.streams[name])) {
+		return fmt.Errorf("es: stream %s is at version %d, cannot snapshot version %d", name, len(s.streams[name]), version)
+	}
+	return s.write(&record{Kind: kind, Stream: name, Version: version, State: state})
+}
+
+// restore decodes the snapshot at ref into v
+func (s *Store) restore(ref snapshotRef, v any) error {
+	rec, _, err := s.readRecord(ref.off, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	return s.opts.Codec.Unmarshal(rec.State, v)
+}
+
+// Close closes the file of the store.
+func (s *Store) Close() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	s.closed = true
+	return s.file.Close()
+}
@@ -0,0 +1,168 @@
+package es_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KarpelesLab/emitter/es"
+)
+
+// account is an aggregate tracking a balance
+type account struct {
+	Balance int `json:"balance"`
+	applied int // events applied since loaded
+}
+
+func (a *account) Apply(ev *es.Event) error {
+	var amount int
+	if err := ev.Decode(&amount); err != nil {
+		return err
+	}
+	switch ev.Type {
+	case "deposited":
+		a.Balance += amount
+	case "withdrawn":
+		a.Balance -= amount
+	default:
+		return fmt.Errorf("unknown event %s", ev.Type)
+	}
+	a.applied += 1
+	return nil
+}
+
+func open(t *testing.T, path string, opts es.Options) *es.Store {
+	t.Helper()
+	s, err := es.Open(path, opts)
+	if err != nil {
+		t.Fatalf("open failed: %s", err)
+	}
+	return s
+}
+
+func TestAggregate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+	s := open(t, path, es.Options{NoSync: true})
+
+	acct := &account{}
+	version, err := s.Load("account/1", acct)
+	if err != nil || version != 0 {
+		t.Fatalf("unexpected load %d, %v", version, err)
+	}
+	version, err = s.Save(ctx, "account/1", acct, version, es.Change{Type: "deposited", Data: 100}, es.Change{Type: "withdrawn", Data: 30})
+	if err != nil || version != 2 || acct.Balance != 70 {
+		t.Fatalf("unexpected save %d, %v, balance %d", version, err, acct.Balance)
+	}
+	s.Append(ctx, "account/2", es.AnyVersion, es.Change{Type: "deposited", Data: 5})
+
+	// a stale version is rejected
+	if _, err := s.Save(ctx, "account/1", &account{}, 1, es.Change{Type: "deposited", Data: 1}); !errors.Is(err, es.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	s.Close()
+	if _, err := s.Append(ctx, "account/1", es.AnyVersion, es.Change{Type: "deposited", Data: 1}); err != es.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	// events are replayed after reopening
+	s = open(t, path, es.Options{})
+	defer s.Close()
+	acct = &account{}
+	if version, err := s.Load("account/1", acct); err != nil || version != 2 || acct.Balance != 70 {
+		t.Errorf("unexpected reload %d, %v, balance %d", version, err, acct.Balance)
+	}
+	if s.Position() != 3 || s.Version("account/2") != 1 {
+		t.Errorf("unexpected position %d and version %d", s.Position(), s.Version("account/2"))
+	}
+
+	var types []string
+	s.Replay(1, func(ev *es.Event) error {
+		types = append(types, ev.Stream+":"+ev.Type)
+		return nil
+	})
+	if len(types) != 2 || types[0] != "account/1:withdrawn" || types[1] != "account/2:deposited" {
+		t.Errorf("unexpected replay %v", types)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+	s := open(t, path, es.Options{NoSync: true, SnapshotEvery: 4})
+
+	acct := &account{}
+	var version uint64
+	for range 10 {
+		var err error
+		version, err = s.Save(ctx, "account/1", acct, version, es.Change{Type: "deposited", Data: 1})
+		if err != nil {
+			t.Fatalf("save failed: %s", err)
+		}
+	}
+	s.Close()
+
+	// only the events following the last snapshot are replayed
+	s = open(t, path, es.Options{})
+	defer s.Close()
+	acct = &account{}
+	if version, err := s.Load("account/1", acct); err != nil || version != 10 || acct.Balance != 10 {
+		t.Fatalf("unexpected load %d, %v, balance %d", version, err, acct.Balance)
+	}
+	if acct.applied != 2 {
+		t.Errorf("expected 2 events replayed after snapshot, got %d", acct.applied)
+	}
+
+	if err := s.Snapshot("account/1", acct, 11); err == nil {
+		t.Errorf("snapshot of a future version was accepted")
+	}
+}
+
+func TestTornRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+	s := open(t, path, es.Options{})
+	s.Append(ctx, "account/1", 0, es.Change{Type: "deposited", Data: 10})
+	s.Close()
+
+	// simulate a crash while writing a record
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{0, 0, 0, 40, 1, 2, 3, 4, '{'})
+	f.Close()
+
+	s = open(t, path, es.Options{})
+	defer s.Close()
+	if _, err := s.Append(ctx, "account/1", 1, es.Change{Type: "deposited", Data: 5}); err != nil {
+		t.Fatalf("append failed: %s", err)
+	}
+	acct := &account{}
+	if version, err := s.Load("account/1", acct); err != nil || version != 2 || acct.Balance != 15 {
+		t.Errorf("unexpected load %d, %v, balance %d", version, err, acct.Balance)
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+	s := open(t, path, es.Options{NoSync: true})
+	for range 5 {
+		s.Append(ctx, "account/1", es.AnyVersion, es.Change{Type: "deposited", Data: 10})
+	}
+	s.Close()
+	st, _ := os.Stat(path)
+
+	// a damaged record in the middle of the file is not taken for a torn one
+	f, _ := os.OpenFile(path, os.O_RDWR, 0)
+	f.WriteAt([]byte{'X'}, 10)
+	f.Close()
+
+	if _, err := es.Open(path, es.Options{}); !errors.Is(err, es.ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+	if st2, _ := os.Stat(path); st2.Size() != st.Size() {
+		t.Errorf("file was truncated from %d to %d bytes", st.Size(), st2.Size())
+	}
+}
@@ -0,0 +1,193 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// Projection keeps a read model up to date from the events of a store. To be
+// snapshotted, projections must be encodable with the codec of the store.
+type Projection interface {
+	Project(ev *Event) error
+}
+
+// ProjectionOptions configures a projection started with [Store.Project].
+type ProjectionOptions struct {
+	// Name identifies the snapshots of the projection. If empty, the projection is not
+	// snapshotted and replays all the events of the store when started.
+	Name string
+
+	// SnapshotEvery, if set, is the number of events after which the projection is
+	// snapshotted. It is also snapshotted when closed.
+	SnapshotEvery int
+
+	// Cap is the capacity of the listener, see [emitter.Hub.OnWithCap].
+	Cap uint
+}
+
+// Projector feeds the events of a store to a projection, see [Store.Project].
+type Projector struct {
+	store   *Store
+	p       Projection
+	opts    ProjectionOptions
+	ch      <-chan *emitter.Event
+	done    chan struct{}
+	lk      sync.Mutex
+	pos     uint64
+	snapPos uint64
+	changed chan struct{} // closed when pos changes
+	err     error
+}
+
+// Project restores p from its latest snapshot, if any, then feeds it the events of the
+// store it did not see yet, followed by the events appended to the store as they are
+// emitted on the hub. Events missed on the hub are read from the store, so p receives
+// each event exactly once and in order.
+//
+// p is called from a single goroutine. Read models accessed concurrently must be
+// protected by the projection, or accessed after [Projector.Wait].
+func (s *Store) Project(p Projection, opts ProjectionOptions) (*Projector, error) {
+	if s.opts.Hub == nil {
+		return nil, ErrNoHub
+	}
+	pr := &Projector{
+		store:   s,
+		p:       p,
+		opts:    opts,
+		done:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+
+	if opts.Name != "" {
+		s.lk.RLock()
+		snap, ok := s.projections[opts.Name]
+		s.lk.RUnlock()
+		if ok {
+			if err := s.restore(snap, p); err != nil {
+				return nil, fmt.Errorf("es: failed to restore projection %s: %w", opts.Name, err)
+			}
+			pr.pos, pr.snapPos = snap.version, snap.version
+		}
+	}
+
+	// subscribe before catching up, so no event is missed in between
+	pr.ch = s.opts.Hub.OnWithCap(s.opts.Topic, opts.Cap)
+	go pr.run()
+	return pr, nil
+}
+
+func (pr *Projector) run() {
+	defer close(pr.done)
+
+	err := pr.store.Replay(pr.pos, pr.apply)
+	pr.fail(err)
+	for ev := range pr.ch {
+		if err != nil {
+			// keep receiving until Close so emitters are not blocked
+			continue
+		}
+		e, ok := ev.Arg(0).(*Event)
+		switch {
+		case !ok || e.Position <= pr.pos:
+			// already seen while catching up
+		case e.Position > pr.pos+1:
+			// events were missed
+			err = pr.store.Replay(pr.pos, pr.apply)
+		default:
+			err = pr.apply(e)
+		}
+		pr.fail(err)
+	}
+}
+
+// fail stops the projection if err is not nil
+func (pr *Projector) fail(err error) {
+	if err == nil {
+		return
+	}
+	pr.lk.Lock()
+	defer pr.lk.Unlock()
+	pr.err = err
+	close(pr.changed)
+}
+
+// apply feeds ev to the projection, it is only called by run
+func (pr *Projector) apply(ev *Event) error {
+	if err := pr.p.Project(ev); err != nil {
+		return fmt.Errorf("es: projection failed at position %d: %w", ev.Position, err)
+	}
+
+	pr.lk.Lock()
+	pr.pos = ev.Position
+	close(pr.changed)
+	pr.changed = make(chan struct{})
+	pr.lk.Unlock()
+
+	if pr.opts.Name != "" && pr.opts.SnapshotEvery > 0 && pr.pos >= pr.snapPos+uint64(pr.opts.SnapshotEvery) {
+		pr.snapshot()
+	}
+	return nil
+}
+
+// snapshot stores the state of the projection, it is only called by run or once run ended
+func (pr *Projector) snapshot() error {
+	if err := pr.store.saveSnapshot(kindProjection, pr.opts.Name, pr.p, pr.pos); err != nil {
+		return err
+	}
+	pr.snapPos = pr.pos
+	return nil
+}
+
+// Position returns the position of the last event fed to the projection.
+func (pr *Projector) Position() uint64 {
+	pr.lk.Lock()
+	defer pr.lk.Unlock()
+	return pr.pos
+}
+
+// Err returns the error which stopped the projection, if any.
+func (pr *Projector) Err() error {
+	pr.lk.Lock()
+	defer pr.lk.Unlock()
+	return pr.err
+}
+
+// Wait waits until the event at position pos, typically returned by [Store.Append], was
+// fed to the projection.
+func (pr *Projector) Wait(ctx context.Context, pos uint64) error {
+	for {
+		pr.lk.Lock()
+		cur, err, changed := pr.pos, pr.err, pr.changed
+		pr.lk.Unlock()
+		if cur >= pos {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-pr.done:
+			if pr.Position() < pos {
+				return ErrClosed
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close stops the projection and snapshots it if it has a name and did not fail.
+func (pr *Projector) Close() error {
+	pr.store.opts.Hub.Off(pr.store.opts.Topic, pr.ch)
+	<-pr.done
+
+	if pr.opts.Name == "" || pr.Err() != nil || pr.pos == pr.snapPos {
+		return nil
+	}
+	return pr.snapshot()
+}
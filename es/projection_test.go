@@ -0,0 +1,132 @@
+package es_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+	"github.com/KarpelesLab/emitter/es"
+)
+
+// balances is a read model of the balance of each account
+type balances struct {
+	Accounts map[string]int `json:"accounts"`
+	seen     int            // events projected since started
+}
+
+func (b *balances) Project(ev *es.Event) error {
+	if b.Accounts == nil {
+		b.Accounts = make(map[string]int)
+	}
+	var amount int
+	if err := ev.Decode(&amount); err != nil {
+		return err
+	}
+	if ev.Type == "withdrawn" {
+		amount = -amount
+	}
+	b.Accounts[ev.Stream] += amount
+	b.seen += 1
+	return nil
+}
+
+func wait(t *testing.T, pr *es.Projector, pos uint64) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pr.Wait(ctx, pos); err != nil {
+		t.Fatalf("wait failed: %s", err)
+	}
+}
+
+func TestProjection(t *testing.T) {
+	ctx := context.Background()
+	h := emitter.New()
+	defer h.Close()
+	path := filepath.Join(t.TempDir(), "events.log")
+	s := open(t, path, es.Options{Hub: h, NoSync: true})
+
+	// events appended before the projection started are replayed
+	s.Append(ctx, "account/1", es.AnyVersion, es.Change{Type: "deposited", Data: 100})
+
+	bal := &balances{}
+	pr, err := s.Project(bal, es.ProjectionOptions{Name: "balances"})
+	if err != nil {
+		t.Fatalf("project failed: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, stream := range []string{"account/1", "account/2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				s.Append(ctx, stream, es.AnyVersion, es.Change{Type: "withdrawn", Data: 1})
+			}
+		}()
+	}
+	wg.Wait()
+	wait(t, pr, s.Position())
+	if bal.Accounts["account/1"] != 80 || bal.Accounts["account/2"] != -20 || bal.seen != 41 {
+		t.Errorf("unexpected read model %v after %d events", bal.Accounts, bal.seen)
+	}
+	if err := pr.Close(); err != nil {
+		t.Fatalf("close failed: %s", err)
+	}
+	s.Close()
+
+	// the projection restarts from its snapshot
+	s = open(t, path, es.Options{Hub: h})
+	defer s.Close()
+	evs, _ := s.Append(ctx, "account/2", es.AnyVersion, es.Change{Type: "deposited", Data: 20})
+
+	bal = &balances{}
+	pr, _ = s.Project(bal, es.ProjectionOptions{Name: "balances"})
+	defer pr.Close()
+	wait(t, pr, evs[0].Position)
+	if bal.Accounts["account/1"] != 80 || bal.Accounts["account/2"] != 0 || bal.seen != 1 {
+		t.Errorf("unexpected read model %v after %d events", bal.Accounts, bal.seen)
+	}
+}
+
+// failing is a projection failing on withdrawals
+type failing struct{}
+
+var errRefused = errors.New("refused")
+
+func (failing) Project(ev *es.Event) error {
+	if ev.Type == "withdrawn" {
+		return errRefused
+	}
+	return nil
+}
+
+func TestProjectionError(t *testing.T) {
+	ctx := context.Background()
+	h := emitter.New()
+	defer h.Close()
+	s := open(t, filepath.Join(t.TempDir(), "events.log"), es.Options{Hub: h, NoSync: true})
+	defer s.Close()
+
+	noHub := open(t, filepath.Join(t.TempDir(), "other.log"), es.Options{})
+	defer noHub.Close()
+	if _, err := noHub.Project(failing{}, es.ProjectionOptions{}); err != es.ErrNoHub {
+		t.Errorf("expected ErrNoHub, got %v", err)
+	}
+
+	pr, _ := s.Project(failing{}, es.ProjectionOptions{})
+	defer pr.Close()
+	s.Append(ctx, "account/1", es.AnyVersion, es.Change{Type: "deposited", Data: 1}, es.Change{Type: "withdrawn", Data: 1})
+
+	// the projection stops at the failing event, without blocking appends
+	if err := pr.Wait(ctx, 2); !errors.Is(err, errRefused) || pr.Position() != 1 {
+		t.Errorf("unexpected wait %v at position %d", err, pr.Position())
+	}
+	if _, err := s.Append(ctx, "account/1", es.AnyVersion, es.Change{Type: "deposited", Data: 1}); err != nil {
+		t.Errorf("append failed: %s", err)
+	}
+}
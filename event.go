@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 
 	"github.com/KarpelesLab/typutil"
 )
@@ -24,9 +25,45 @@ type Event struct {
 	// Topic is the name of the topic this event was emitted on.
 	Topic string
 
+	// CurrentTopic is the name of the topic the event is being delivered on. It is
+	// the same as Topic unless the event is propagating to parent topics, see
+	// [Hub.EmitBubble].
+	CurrentTopic string
+
 	// Args contains the arguments passed to [Hub.Emit].
 	Args []any
 
+	// Header contains optional metadata attached to the event, which can be
+	// matched by filters (see [Match]). It must not be modified once the
+	// event has been emitted.
+	Header map[string]string
+
+	// ID uniquely identifies the event, and is set by the emitter. It is kept when events
+	// are bridged, sent over ipc or re-driven, allowing duplicates to be dropped, see
+	// [Hub.SetDedup].
+	ID string
+
+	// Key identifies the entity the event relates to, such as an order ID. On topics with
+	// [Keyed] ordering, events with the same key are received in the same order by all
+	// listeners.
+	Key string
+
+	// Priority is the priority given to [Hub.EmitPriority]. Listeners created with
+	// [Hub.OnPriority] receive events of higher priority first.
+	Priority int
+
+	// Attempt is the number of the current attempt at handling the event, starting at 1.
+	// It is only set on events passed to handlers registered with [Hub.HandleWithRetry].
+	Attempt int
+
+	pattern *pattern        // pattern through which the event was received, if any
+	stopped *atomic.Bool    // propagation state, shared by all levels when bubbling
+	report  *deliveryCounts // delivery counts of async emits, shared by all levels when bubbling
+	parent  *Event          // event this one was cloned from, sharing its encoded args
+
+	attempts   int  // number of previous failed deliveries, for re-driven events
+	deadLetter bool // event carrying a DeadLetter, which is never dead-lettered itself
+
 	argAs   []map[string]*encodedArg
 	argAsLk sync.Mutex
 }
@@ -96,6 +133,9 @@ func (ev *Event) getEncodedArg(n uint, key string) *encodedArg {
 //
 //	jsonBytes, err := ev.EncodedArg(0, "json", json.Marshal)
 func (ev *Event) EncodedArg(n uint, key string, encoder ArgEncoder) ([]byte, error) {
+	if ev.parent != nil {
+		return ev.parent.EncodedArg(n, key, encoder)
+	}
 	ea := ev.getEncodedArg(n, key)
 	if ea == nil {
 		return nil, errors.New("invalid event argument number")
@@ -105,3 +145,37 @@ func (ev *Event) EncodedArg(n uint, key string, encoder ArgEncoder) ([]byte, err
 	})
 	return ea.buf, ea.err
 }
+
+// current returns the topic ev is being delivered on
+func (ev *Event) current() string {
+	if ev.CurrentTopic != "" {
+		return ev.CurrentTopic
+	}
+	return ev.Topic
+}
+
+// clone returns a copy of ev sharing the same arguments, headers, propagation state
+// and encoded arguments cache.
+func (ev *Event) clone() *Event {
+	parent := ev
+	if ev.parent != nil {
+		parent = ev.parent
+	}
+	return &Event{
+		Context:      ev.Context,
+		Topic:        ev.Topic,
+		CurrentTopic: ev.CurrentTopic,
+		Args:         ev.Args,
+		Header:       ev.Header,
+		ID:           ev.ID,
+		Key:          ev.Key,
+		Priority:     ev.Priority,
+		Attempt:      ev.Attempt,
+		pattern:      ev.pattern,
+		stopped:      ev.stopped,
+		report:       ev.report,
+		parent:       parent,
+		attempts:     ev.attempts,
+		deadLetter:   ev.deadLetter,
+	}
+}
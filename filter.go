@@ -0,0 +1,39 @@
+package emitter
+
+import "github.com/KarpelesLab/typutil"
+
+// Match is a declarative filter that can be used with [Hub.OnMatch]. An event matches
+// if all the given arguments and header values are equal to the expected ones.
+//
+// Example:
+//
+//	ch := h.OnMatch("order", emitter.Match{
+//	    Args:   map[uint]any{0: "paid"},
+//	    Header: map[string]string{"tenant": "acme"},
+//	})
+type Match struct {
+	// Args maps argument positions to their expected values. Values are compared
+	// loosely, so that 42 matches "42".
+	Args map[uint]any
+
+	// Header maps header keys to their expected values.
+	Header map[string]string
+}
+
+// Matches returns true if ev matches all the conditions in m.
+func (m Match) Matches(ev *Event) bool {
+	for n, v := range m.Args {
+		if n >= uint(len(ev.Args)) {
+			return false
+		}
+		if !typutil.Equal(ev.Args[n], v) {
+			return false
+		}
+	}
+	for k, v := range m.Header {
+		if hv, ok := ev.Header[k]; !ok || hv != v {
+			return false
+		}
+	}
+	return true
+}
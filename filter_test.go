@@ -0,0 +1,93 @@
+package emitter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func TestOnFilter(t *testing.T) {
+	h := emitter.New()
+
+	ch := h.OnFilter("test", func(ev *emitter.Event) bool {
+		v, _ := emitter.Arg[int](ev, 0)
+		return v%2 == 0
+	})
+
+	go func() {
+		for i := 0; i < 6; i++ {
+			// odd events must not block even though nobody reads them
+			if err := h.EmitTimeout(time.Second, "test", i); err != nil {
+				t.Errorf("Emit failed: %v", err)
+			}
+		}
+	}()
+
+	for _, expect := range []int{0, 2, 4} {
+		ev := <-ch
+		v, _ := emitter.Arg[int](ev, 0)
+		if v != expect {
+			t.Errorf("unexpected value %d, expected %d", v, expect)
+		}
+	}
+}
+
+func TestOnMatch(t *testing.T) {
+	h := emitter.New()
+	h.Cap = 1
+
+	ch := h.OnMatch("order", emitter.Match{
+		Args:   map[uint]any{0: "paid", 1: 42},
+		Header: map[string]string{"tenant": "acme"},
+	})
+
+	events := []*emitter.Event{
+		{Args: []any{"paid", 42}, Header: map[string]string{"tenant": "other"}},
+		{Args: []any{"created", 42}, Header: map[string]string{"tenant": "acme"}},
+		{Args: []any{"paid"}, Header: map[string]string{"tenant": "acme"}},
+		{Args: []any{"paid", "42"}, Header: map[string]string{"tenant": "acme"}},
+	}
+	for _, ev := range events {
+		if err := h.EmitEventTimeout(time.Second, "order", ev); err != nil {
+			t.Fatalf("EmitEvent failed: %v", err)
+		}
+	}
+
+	ev := <-ch
+	if ev != events[3] {
+		t.Errorf("unexpected event received: %v", ev.Args)
+	}
+}
+
+func TestOnFilterPanic(t *testing.T) {
+	h := emitter.New()
+
+	reported := make(chan error, 1)
+	h.OnError = func(err error) {
+		reported <- err
+	}
+
+	_ = h.OnFilter("test", func(ev *emitter.Event) bool {
+		panic("bad filter")
+	})
+	ok := h.OnWithCap("test", 1)
+
+	if err := h.Emit(context.Background(), "test", "data"); err != nil {
+		t.Errorf("Emit failed: %v", err)
+	}
+	if _, got := <-ok; !got {
+		t.Error("expected event on non-filtered listener")
+	}
+
+	select {
+	case err := <-reported:
+		if !errors.Is(err, emitter.ErrFilterPanic) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("filter panic was not reported")
+	}
+}
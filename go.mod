@@ -1,6 +1,6 @@
 module github.com/KarpelesLab/emitter
 
-go 1.22.2
+go 1.23
 
 require github.com/KarpelesLab/typutil v0.2.16
 
@@ -2,7 +2,11 @@ package emitter
 
 import (
 	"context"
+	"log"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,11 +24,49 @@ type Hub struct {
 	// Cap is the default channel capacity for new listeners created with [Hub.On].
 	// Set this before creating listeners to change the default buffer size.
 	// The default value of 0 means unbuffered channels.
-	Cap      uint
+	Cap uint
+
+	// OnError, if set, is called with errors that happen during emit but are not
+	// returned to the emitter, such as a listener filter panicking. If nil, these
+	// errors are written to the standard logger.
+	OnError func(error)
+
+	// Bubble, if set, makes all events emitted on this hub propagate to parent topics,
+	// as with [Hub.EmitBubble].
+	Bubble bool
+
+	// Breaker, if set, enables circuit breakers on channel listeners. A listener failing to
+	// receive events before the emit context deadline several times in a row is skipped,
+	// without making emitters wait, until it is probed again after a cooldown. Transitions
+	// are emitted on [BreakerTopic], and dropped events are dead-lettered with
+	// [ErrBreakerOpen]. It is read during emit, and must be set before emitting. Namespaces
+	// use the Breaker of the root hub, and their own is ignored.
+	Breaker *BreakerPolicy
+
+	// MaxInFlight is the maximum number of emits performed at the same time by
+	// [Hub.EmitAsync], and defaults to [DefaultMaxInFlight]. It is read on the first
+	// async emit. The limit is shared by namespaces, which use the MaxInFlight of the
+	// root hub and ignore their own.
+	MaxInFlight int
+
+	parent *Hub   // root hub holding the storage, if this is a namespace
+	prefix string // namespace prefix, including the parent's prefix
+
 	topics   map[string]*topic
+	patterns []*patternListener // protected by topicsLk
 	topicsLk sync.RWMutex
+	txLks    map[string]*nameLock // tx locks of transient topics, see topic.txLock
+	txLksLk  sync.Mutex
 	trig     map[string]Trigger
 	trigLk   sync.RWMutex
+
+	dlq      map[string]chan *DeadLetter // pending dead letters, see Hub.deadLetter
+	dlqDrops map[string]uint64
+	dlqLk    sync.Mutex
+
+	async     *dispatcher // async emits, see Hub.EmitAsync
+	asyncOnce sync.Once
+	batches   sync.Map // listener channels of batch and window channels
 }
 
 // New creates and returns a new Hub instance with default settings.
@@ -33,12 +75,22 @@ func New() *Hub {
 }
 
 func (h *Hub) getTopic(topicName string, create bool) *topic {
+	if h.parent != nil {
+		return h.parent.getTopic(topicName, create)
+	}
+
 	h.topicsLk.RLock()
 	var t *topic
 	var ok bool
 	if h.topics != nil {
 		t, ok = h.topics[topicName]
 	}
+	if !ok && !create && h.matchPattern(topicName) {
+		// topics only reached through patterns are not stored, so they do not accumulate
+		t, ok = newTopic(h), true
+		t.transient = true
+		t.name = topicName
+	}
 	h.topicsLk.RUnlock()
 	if ok {
 		return t
@@ -51,14 +103,21 @@ func (h *Hub) getTopic(topicName string, create bool) *topic {
 
 	if h.topics == nil {
 		h.topics = make(map[string]*topic)
+	} else if t, ok = h.topics[topicName]; ok {
+		// created by someone else meanwhile
+		return t
 	}
 
-	t = newTopic()
+	t = newTopic(h)
 	h.topics[topicName] = t
 	return t
 }
 
 func (h *Hub) getTrigger(trigName string, create bool) Trigger {
+	if h.parent != nil {
+		return h.parent.getTrigger(trigName, create)
+	}
+
 	h.trigLk.RLock()
 	var t Trigger
 	var ok bool
@@ -77,6 +136,8 @@ func (h *Hub) getTrigger(trigName string, create bool) Trigger {
 
 	if h.trig == nil {
 		h.trig = make(map[string]Trigger)
+	} else if t, ok = h.trig[trigName]; ok {
+		return t
 	}
 
 	t = NewTrigger()
@@ -86,19 +147,107 @@ func (h *Hub) getTrigger(trigName string, create bool) Trigger {
 
 // On returns a channel that will receive events
 func (h *Hub) On(topic string) <-chan *Event {
-	return h.getTopic(topic, true).newListener(h.Cap)
+	return h.getTopic(h.name(topic), true).newListener(h.Cap, nil)
 }
 
 // OnWithCap returns a channel that will receive events, and has the given capacity instead of the default one
 func (h *Hub) OnWithCap(topic string, c uint) <-chan *Event {
-	return h.getTopic(topic, true).newListener(c)
+	return h.getTopic(h.name(topic), true).newListener(c, nil)
+}
+
+// OnFilter returns a channel that will receive only the events for which filter returns true.
+// The filter is called during emit, before the event is sent, so events that are filtered out
+// never occupy the channel's capacity nor block the emitter. Filters must be fast, and must not
+// modify the event. If filter panics, the event is not delivered and the panic is reported
+// through [Hub.OnError].
+func (h *Hub) OnFilter(topic string, filter func(*Event) bool) <-chan *Event {
+	return h.getTopic(h.name(topic), true).newListener(h.Cap, filter)
+}
+
+// OnMatch returns a channel that will receive only the events matching m. See [Hub.OnFilter].
+func (h *Hub) OnMatch(topic string, m Match) <-chan *Event {
+	return h.OnFilter(topic, m.Matches)
+}
+
+func (h *Hub) reportError(err error) {
+	if h.OnError != nil {
+		h.OnError(err)
+		return
+	}
+	log.Printf("emitter: %s", err)
+}
+
+// OnMany returns a single channel that will receive events from all the given topics, with
+// the given capacity. Each event keeps its own [Event.Topic] so the origin can be known.
+//
+// Events emitted on a given topic are received in the order they were emitted, however there
+// is no ordering guarantee between events of different topics: two events emitted on different
+// topics may be received in any order, even if emitted sequentially by the same goroutine.
+//
+// The channel can be detached from a single topic with [Hub.Off], or from all of them at once
+// with [Hub.Unsubscribe]. It is closed once it isn't attached to any topic anymore.
+func (h *Hub) OnMany(topics []string, c uint) <-chan *Event {
+	l := newListener(c)
+	ch := l.ch
+
+	// hold a reference while attaching so l can't be closed if one of the topics is closed meanwhile
+	atomic.AddInt32(&l.refs, 1)
+	defer l.release()
+
+	for _, topic := range topics {
+		h.getTopic(h.name(topic), true).appendListener(l, ch)
+	}
+	return ch
+}
+
+// attach subscribes l to the given topic, or to all the topics matching it if it is a pattern
+func (h *Hub) attach(topic string, l *listener, ch <-chan *Event) error {
+	topic = h.name(topic)
+	if IsPattern(topic) {
+		p, err := parsePattern(topic)
+		if err != nil {
+			return err
+		}
+		h.addPattern(p, l, ch)
+		return nil
+	}
+	h.getTopic(topic, true).appendListener(l, ch)
+	return nil
+}
+
+// findListener returns the listener receiving on ch, or nil if ch is not attached to any topic
+func (h *Hub) findListener(ch <-chan *Event) *listener {
+	root := h.root()
+
+	root.topicsLk.RLock()
+	for _, pl := range root.patterns {
+		if pl.ch == ch {
+			root.topicsLk.RUnlock()
+			return pl.l
+		}
+	}
+	topics := make([]*topic, 0, len(root.topics))
+	for _, t := range root.topics {
+		topics = append(topics, t)
+	}
+	root.topicsLk.RUnlock()
+
+	for _, t := range topics {
+		t.listenersLk.RLock()
+		l := t.listeners[ch]
+		t.listenersLk.RUnlock()
+		if l != nil {
+			return l
+		}
+	}
+	return nil
 }
 
 // Push sends a signal to the named trigger, waking all its listeners.
 // If the trigger does not exist, this method does nothing.
 // Unlike [Hub.Emit], Push returns immediately and is non-blocking.
 func (h *Hub) Push(trigger string) {
-	t := h.getTrigger(trigger, false)
+	t := h.getTrigger(h.name(trigger), false)
 	if t != nil {
 		t.Push()
 	}
@@ -107,13 +256,13 @@ func (h *Hub) Push(trigger string) {
 // Trigger returns the given trigger, creating it if needed. This can make it easy to call methods like Listen()
 // or Push() in one go.
 func (h *Hub) Trigger(trigName string) Trigger {
-	return h.getTrigger(trigName, true)
+	return h.getTrigger(h.name(trigName), true)
 }
 
 // Off unsubscribes from a given topic. If ch is nil, the whole topic is closed, otherwise only the given
 // channel is removed from the topic. Note that the channel will be closed in the process.
 func (h *Hub) Off(topic string, ch <-chan *Event) {
-	t := h.getTopic(topic, false)
+	t := h.getTopic(h.name(topic), false)
 	if t == nil {
 		return
 	}
@@ -127,20 +276,83 @@ func (h *Hub) Off(topic string, ch <-chan *Event) {
 	}
 }
 
-// Close will turn off all of the hub's topics, ending all listeners.
+// Unsubscribe detaches the given channel from all the topics it is listening to, and closes it.
+// This is mostly useful for channels returned by [Hub.OnMany] and [Hub.OnPattern].
+func (h *Hub) Unsubscribe(ch <-chan *Event) {
+	if h.parent != nil {
+		h.parent.Unsubscribe(ch)
+		return
+	}
+
+	h.topicsLk.Lock()
+	var released []*patternListener
+	h.patterns = slices.DeleteFunc(h.patterns, func(pl *patternListener) bool {
+		if pl.ch == ch {
+			released = append(released, pl)
+			return true
+		}
+		return false
+	})
+	topics := make([]*topic, 0, len(h.topics))
+	for _, t := range h.topics {
+		topics = append(topics, t)
+	}
+	h.topicsLk.Unlock()
+
+	for _, t := range topics {
+		t.remove(ch)
+	}
+	for _, pl := range released {
+		pl.l.release()
+	}
+}
+
+// Close will turn off all of the hub's topics, ending all listeners. If the hub is a namespace,
+// only the topics and triggers of the namespace are closed.
 func (h *Hub) Close() error {
+	if h.parent != nil {
+		return h.parent.closeNamespace(h.prefix + "/")
+	}
+	return h.closeNamespace("")
+}
+
+// closeNamespace closes all topics, patterns and triggers whose name start with prefix
+func (h *Hub) closeNamespace(prefix string) error {
+	var topics []*topic
+	var patterns []*patternListener
+	var trig []Trigger
+
 	h.topicsLk.Lock()
-	topics := h.topics
-	h.topics = nil
+	for name, t := range h.topics {
+		if strings.HasPrefix(name, prefix) {
+			topics = append(topics, t)
+			delete(h.topics, name)
+		}
+	}
+	h.patterns = slices.DeleteFunc(h.patterns, func(pl *patternListener) bool {
+		if strings.HasPrefix(pl.pat.str, prefix) {
+			patterns = append(patterns, pl)
+			return true
+		}
+		return false
+	})
 	h.topicsLk.Unlock()
+
 	h.trigLk.Lock()
-	trig := h.trig
-	h.trig = nil
+	for name, t := range h.trig {
+		if strings.HasPrefix(name, prefix) {
+			trig = append(trig, t)
+			delete(h.trig, name)
+		}
+	}
 	h.trigLk.Unlock()
 
 	for _, t := range topics {
 		t.close()
 	}
+	for _, pl := range patterns {
+		pl.l.release()
+	}
 	for _, t := range trig {
 		t.Close()
 	}
@@ -148,20 +360,16 @@ func (h *Hub) Close() error {
 }
 
 // Emit emits an event on the given topic, and will not return until the event has been
-// added to all the queues, or the context expires.
+// added to all the queues, or the context expires. If [Hub.Bubble] is set, the event then
+// propagates to parent topics, see [Hub.EmitBubble].
 func (h *Hub) Emit(ctx context.Context, topic string, args ...any) error {
-	t := h.getTopic(topic, false)
-	if t == nil {
-		return ErrNoSuchTopic
-	}
-
 	ev := &Event{
 		Context: ctx,
-		Topic:   topic,
+		Topic:   h.name(topic),
 		Args:    args,
 	}
 
-	return t.emit(ctx, ev)
+	return h.emitEvent(ctx, ev, h.Bubble)
 }
 
 // EmitTimeout emits an event with a given timeout instead of using a context. This is useful
@@ -177,13 +385,22 @@ func (h *Hub) EmitTimeout(timeout time.Duration, topic string, args ...any) erro
 
 // EmitEvent emits an existing [Event] object without copying it.
 func (h *Hub) EmitEvent(ctx context.Context, topic string, ev *Event) error {
-	ev.Topic = topic
+	ev.Topic = h.name(topic)
+
+	return h.emitEvent(ctx, ev, h.Bubble)
+}
+
+func (h *Hub) emitEvent(ctx context.Context, ev *Event, bubble bool) error {
+	if bubble {
+		return h.emitBubble(ctx, ev)
+	}
 
-	t := h.getTopic(topic, false)
+	t := h.getTopic(ev.Topic, false)
 	if t == nil {
 		return ErrNoSuchTopic
 	}
 
+	ev.CurrentTopic = ev.Topic
 	return t.emit(ctx, ev)
 }
 
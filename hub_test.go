@@ -388,3 +388,76 @@ func TestHubCap(t *testing.T) {
 		}
 	}
 }
+
+func TestOnMany(t *testing.T) {
+	h := emitter.New()
+
+	ch := h.OnMany([]string{"a", "b", "c"}, 10)
+
+	for _, topic := range []string{"a", "b", "c"} {
+		for i := 0; i < 3; i++ {
+			if err := h.Emit(context.Background(), topic, i); err != nil {
+				t.Fatalf("Emit failed: %v", err)
+			}
+		}
+	}
+
+	next := map[string]int{}
+	for i := 0; i < 9; i++ {
+		ev := <-ch
+		v, _ := emitter.Arg[int](ev, 0)
+		if v != next[ev.Topic] {
+			t.Errorf("unexpected value %d on topic %s, expected %d", v, ev.Topic, next[ev.Topic])
+		}
+		next[ev.Topic] += 1
+	}
+	if len(next) != 3 {
+		t.Errorf("expected events from 3 topics, got %d", len(next))
+	}
+}
+
+func TestOnManyOff(t *testing.T) {
+	h := emitter.New()
+
+	ch := h.OnMany([]string{"a", "b"}, 1)
+
+	// detaching from a single topic must not close the channel
+	h.Off("a", ch)
+	if err := h.Emit(context.Background(), "b", "data"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	ev, ok := <-ch
+	if !ok || ev.Topic != "b" {
+		t.Fatalf("expected event from topic b")
+	}
+
+	h.Off("b", ch)
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("expected channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for channel to close")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	h := emitter.New()
+
+	ch := h.OnMany([]string{"a", "b", "c"}, 0)
+	other := h.On("a")
+
+	h.Unsubscribe(ch)
+	for range ch {
+		// wait for channel to close
+	}
+
+	go func() {
+		<-other
+	}()
+	// only the other listener remains, emit must not block
+	if err := h.EmitTimeout(time.Second, "a", "data"); err != nil {
+		t.Errorf("Emit failed: %v", err)
+	}
+}
@@ -0,0 +1,403 @@
+// Package ws is a minimal WebSocket (RFC 6455) implementation used by the emitter
+// gateways. It supports both the server and client sides, fragmented messages and
+// control frames, but no extensions.
+package ws
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xa
+)
+
+// DefaultMaxMessageSize is the default maximum size of a received message.
+const DefaultMaxMessageSize = 16 << 20
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	// ErrMessageTooLarge is returned when a received message exceeds the maximum size.
+	ErrMessageTooLarge = errors.New("ws: message too large")
+
+	// ErrProtocol is returned when the remote side violates the protocol.
+	ErrProtocol = errors.New("ws: protocol error")
+)
+
+// Conn is a WebSocket connection. Reads and writes can happen concurrently, but only
+// one goroutine may read at a time.
+type Conn struct {
+	c        net.Conn
+	br       *bufio.Reader
+	client   bool // frames sent by clients are masked
+	wlk      sync.Mutex
+	closed   bool
+	Protocol string // negotiated subprotocol
+
+	// MaxMessageSize is the maximum size of received messages.
+	MaxMessageSize int
+}
+
+func newConn(c net.Conn, br *bufio.Reader, client bool) *Conn {
+	return &Conn{
+		c:              c,
+		br:             br,
+		client:         client,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// IsUpgrade returns true if r is a WebSocket upgrade request.
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade performs the server side of the WebSocket handshake. If protocols is not
+// empty, the first protocol requested by the client that is in the list is selected,
+// and the handshake fails if there is none.
+func Upgrade(w http.ResponseWriter, r *http.Request, protocols []string) (*Conn, error) {
+	key := r.Header.Get("Sec-Websocket-Key")
+	if r.Method != http.MethodGet || !IsUpgrade(r) || key == "" || r.Header.Get("Sec-Websocket-Version") != "13" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, fmt.Errorf("%w: invalid upgrade request", ErrProtocol)
+	}
+
+	var proto string
+	if len(protocols) > 0 {
+	search:
+		for _, v := range r.Header.Values("Sec-Websocket-Protocol") {
+			for _, p := range strings.Split(v, ",") {
+				if p = strings.TrimSpace(p); slices.Contains(protocols, p) {
+					proto = p
+					break search
+				}
+			}
+		}
+		if proto == "" {
+			http.Error(w, "unsupported websocket protocol", http.StatusBadRequest)
+			return nil, fmt.Errorf("%w: no supported subprotocol", ErrProtocol)
+		}
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("ws: response does not support hijacking")
+	}
+	c, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if proto != "" {
+		resp += "Sec-WebSocket-Protocol: " + proto + "\r\n"
+	}
+	resp += "\r\n"
+	if _, err := c.Write([]byte(resp)); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	conn := newConn(c, brw.Reader, false)
+	conn.Protocol = proto
+	return conn, nil
+}
+
+// Dial opens a client WebSocket connection to url, which must use the ws scheme. If
+// protocol is not empty, it is requested as subprotocol.
+func Dial(ctx context.Context, url string, protocol string, header http.Header) (*Conn, error) {
+	addr, ok := strings.CutPrefix(url, "ws://")
+	if !ok {
+		return nil, fmt.Errorf("ws: unsupported url %s", url)
+	}
+	path := "/"
+	if pos := strings.IndexByte(addr, '/'); pos != -1 {
+		addr, path = addr[:pos], addr[pos:]
+	}
+
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+		defer c.SetDeadline(time.Time{})
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if protocol != "" {
+		req.Header.Set("Sec-WebSocket-Protocol", protocol)
+	}
+	if err := req.Write(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-Websocket-Accept") != acceptKey(key) {
+		c.Close()
+		return nil, fmt.Errorf("ws: handshake failed with status %s", resp.Status)
+	}
+
+	conn := newConn(c, br, true)
+	conn.Protocol = resp.Header.Get("Sec-Websocket-Protocol")
+	return conn, nil
+}
+
+// ReadMessage returns the next data message. Control frames are handled transparently:
+// pings are answered, and a close frame results in [io.EOF].
+func (c *Conn) ReadMessage() (op int, data []byte, err error) {
+	op = -1
+	for {
+		fin, fop, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch fop {
+		case OpPing:
+			c.writeFrame(OpPong, payload)
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			c.writeFrame(OpClose, payload)
+			c.c.Close()
+			return 0, nil, io.EOF
+		case OpContinuation:
+			if op == -1 {
+				return 0, nil, ErrProtocol
+			}
+		case OpText, OpBinary:
+			if op != -1 {
+				return 0, nil, ErrProtocol
+			}
+			op = fop
+		default:
+			return 0, nil, ErrProtocol
+		}
+
+		if len(data)+len(payload) > c.MaxMessageSize {
+			return 0, nil, ErrMessageTooLarge
+		}
+		data = append(data, payload...)
+		if fin {
+			return op, data, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	op = int(hdr[0] & 0x0f)
+	masked := hdr[1]&0x80 != 0
+	if masked == c.client || hdr[0]&0x70 != 0 {
+		// servers must receive masked frames, clients unmasked ones, and no extension is supported
+		err = ErrProtocol
+		return
+	}
+
+	ln := uint64(hdr[1] & 0x7f)
+	switch ln {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		ln = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		ln = binary.BigEndian.Uint64(ext[:])
+	}
+	if ln > uint64(c.MaxMessageSize) {
+		err = ErrMessageTooLarge
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, ln)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for n := range payload {
+			payload[n] ^= mask[n%4]
+		}
+	}
+	return
+}
+
+// WriteMessage sends a single message with the given opcode.
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	return c.writeFrame(op, data)
+}
+
+func (c *Conn) writeFrame(op int, data []byte) error {
+	c.wlk.Lock()
+	defer c.wlk.Unlock()
+	return c.writeFrameLocked(op, data)
+}
+
+func (c *Conn) writeFrameLocked(op int, data []byte) error {
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	frame := make([]byte, 0, len(data)+14)
+	frame = append(frame, 0x80|byte(op))
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(data) < 126:
+		frame = append(frame, maskBit|byte(len(data)))
+	case len(data) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(data)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(data)))
+	}
+
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, data...)
+		for n := range data {
+			frame[start+n] ^= mask[n%4]
+		}
+	} else {
+		frame = append(frame, data...)
+	}
+
+	_, err := c.c.Write(frame)
+	if op == OpClose {
+		c.closed = true
+	}
+	return err
+}
+
+// SetReadDeadline sets the deadline for future reads.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.c.SetReadDeadline(t)
+}
+
+// Close sends a close frame and closes the connection. The close frame is skipped if a
+// write is in progress, as it may be blocked by a peer not reading.
+func (c *Conn) Close() error {
+	if c.wlk.TryLock() {
+		c.c.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrameLocked(OpClose, []byte{0x03, 0xe8}) // 1000 normal closure
+		c.wlk.Unlock()
+	}
+	return c.c.Close()
+}
+
+// NetConn returns a [net.Conn] exposing the WebSocket as a byte stream. Each call to
+// Write sends a single binary message, while Read returns the content of received
+// messages in sequence, regardless of message boundaries.
+func (c *Conn) NetConn() net.Conn {
+	return &streamConn{Conn: c}
+}
+
+type streamConn struct {
+	*Conn
+	buf []byte
+}
+
+func (s *streamConn) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		_, data, err := s.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		s.buf = data
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *streamConn) Write(p []byte) (int, error) {
+	if err := s.WriteMessage(OpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *streamConn) LocalAddr() net.Addr                { return s.c.LocalAddr() }
+func (s *streamConn) RemoteAddr() net.Addr               { return s.c.RemoteAddr() }
+func (s *streamConn) SetDeadline(t time.Time) error      { return s.c.SetDeadline(t) }
+func (s *streamConn) SetWriteDeadline(t time.Time) error { return s.c.SetWriteDeadline(t) }
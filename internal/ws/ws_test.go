@@ -0,0 +1,59 @@
+package ws_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter/internal/ws"
+)
+
+func TestEcho(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := ws.Upgrade(w, r, []string{"echo"})
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			op, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(op, data)
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := "ws://" + strings.TrimPrefix(srv.URL, "http://") + "/"
+	c, err := ws.Dial(ctx, url, "echo", nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer c.Close()
+	if c.Protocol != "echo" {
+		t.Errorf("unexpected protocol %q", c.Protocol)
+	}
+
+	for _, msg := range []string{"hello", strings.Repeat("x", 200), strings.Repeat("y", 70000)} {
+		if err := c.WriteMessage(ws.OpText, []byte(msg)); err != nil {
+			t.Fatalf("failed to write: %s", err)
+		}
+		op, data, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read: %s", err)
+		}
+		if op != ws.OpText || string(data) != msg {
+			t.Errorf("unexpected echo of %d bytes: op=%d len=%d", len(msg), op, len(data))
+		}
+	}
+
+	if _, err := ws.Dial(ctx, url, "other", nil); err == nil {
+		t.Errorf("expected unsupported protocol to be refused")
+	}
+}
@@ -0,0 +1,546 @@
+package ipc
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+var (
+	// ErrClosed is returned when using a [RemoteHub] whose connection has been closed.
+	ErrClosed = errors.New("ipc: connection closed")
+
+	// ErrBufferFull is returned by [RemoteHub.Emit] when the connection is down and
+	// too many requests are already waiting for it to be reestablished.
+	ErrBufferFull = errors.New("ipc: buffer full")
+)
+
+const (
+	// DefaultBufferSize is the default value of [Options.BufferSize].
+	DefaultBufferSize = 1024
+
+	// DefaultQueueSize is the default value of [Options.QueueSize].
+	DefaultQueueSize = 1024
+)
+
+// Options configures a [RemoteHub] created with [NewClient].
+type Options struct {
+	// Codec is used to encode messages, and defaults to [emitter.JSONCodec].
+	Codec emitter.Codec
+
+	// Reconnect, if set, makes the client reconnect automatically when the connection
+	// is lost. Subscriptions are restored once reconnected, and requests made while
+	// disconnected are sent once the connection is reestablished.
+	Reconnect bool
+
+	// Backoff is the delay between reconnection attempts, and defaults to
+	// [emitter.DefaultBackoff].
+	Backoff emitter.Backoff
+
+	// BufferSize is the maximum number of requests waiting for the connection to be
+	// reestablished. Defaults to [DefaultBufferSize].
+	BufferSize int
+
+	// QueueSize is the maximum number of received events waiting to be read from the
+	// channel of each subscription. Further events are dropped, see [RemoteHub.Dropped].
+	// Defaults to [DefaultQueueSize].
+	QueueSize int
+}
+
+// RemoteHub is a client connected to a hub served by a [Server]. Subscriptions made
+// through the client are listeners on the server's hub, and events are forwarded over
+// the connection.
+type RemoteHub struct {
+	dial   func(context.Context) (net.Conn, error)
+	opts   Options
+	ctx    context.Context
+	cancel func()
+	wlk    sync.Mutex
+
+	lk      sync.Mutex
+	c       net.Conn // nil while disconnected
+	gen     int      // incremented on each connection
+	nextID  uint64
+	pending map[uint64]*request
+	subs    map[uint64]*remoteSub
+	byCh    map[<-chan *emitter.Event]*remoteSub
+	closed  bool
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+type request struct {
+	msg *message
+	res chan error
+	gen int // connection the request was sent on
+}
+
+// remoteSub is a subscription. Received events are queued, and sent to ch by its own
+// goroutine so a subscription which is not read does not block the others.
+type remoteSub struct {
+	id    uint64
+	topic string
+	cap   uint
+	gen   int // connection the subscription was sent on
+	ch    chan *emitter.Event
+	lk    sync.Mutex // protects queue
+	queue []*emitter.Event
+	wake  chan struct{}
+	done  chan struct{}
+}
+
+// Dial connects to a server listening on the unix domain socket at path.
+func Dial(path string) (*RemoteHub, error) {
+	var d net.Dialer
+	return NewClient(func(ctx context.Context) (net.Conn, error) {
+		return d.DialContext(ctx, "unix", path)
+	}, Options{})
+}
+
+// NewRemoteHub returns a client using an already established connection to a server.
+func NewRemoteHub(c net.Conn, codec emitter.Codec) *RemoteHub {
+	r := newRemoteHub(nil, Options{Codec: codec})
+	r.c = c
+	r.gen = 1
+	go r.run(c)
+	return r
+}
+
+// NewClient returns a client connecting to a server using the given dial function. The
+// initial connection is established before returning. If opts.Reconnect is set, dial is
+// called again each time the connection is lost.
+func NewClient(dial func(context.Context) (net.Conn, error), opts Options) (*RemoteHub, error) {
+	r := newRemoteHub(dial, opts)
+
+	c, err := dial(r.ctx)
+	if err != nil {
+		r.cancel()
+		return nil, err
+	}
+	r.c = c
+	r.gen = 1
+	go r.run(c)
+	return r, nil
+}
+
+func newRemoteHub(dial func(context.Context) (net.Conn, error), opts Options) *RemoteHub {
+	if opts.Codec == nil {
+		opts.Codec = emitter.JSONCodec
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = emitter.DefaultBackoff
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &RemoteHub{
+		dial:    dial,
+		opts:    opts,
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: make(map[uint64]*request),
+		subs:    make(map[uint64]*remoteSub),
+		byCh:    make(map[<-chan *emitter.Event]*remoteSub),
+		done:    make(chan struct{}),
+	}
+}
+
+// On subscribes to the given topic or topic pattern on the server, and returns a channel
+// receiving its events. Capacity of the listener on the server is zero.
+func (r *RemoteHub) On(topic string) (<-chan *emitter.Event, error) {
+	return r.OnWithCap(topic, 0)
+}
+
+// OnWithCap is similar to [RemoteHub.On] but uses the given capacity for both the local
+// channel and the listener on the server.
+func (r *RemoteHub) OnWithCap(topic string, c uint) (<-chan *emitter.Event, error) {
+	r.lk.Lock()
+	if r.closed {
+		r.lk.Unlock()
+		return nil, ErrClosed
+	}
+	r.nextID += 1
+	sub := &remoteSub{
+		id:    r.nextID,
+		topic: topic,
+		cap:   c,
+		ch:    make(chan *emitter.Event, c),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	r.subs[sub.id] = sub
+	r.byCh[sub.ch] = sub
+	r.lk.Unlock()
+	go sub.run()
+
+	err := r.request(context.Background(), sub.message())
+	if err != nil {
+		r.drop(sub)
+		return nil, err
+	}
+	return sub.ch, nil
+}
+
+// Off unsubscribes the given channel, and closes it. Events still queued are discarded.
+func (r *RemoteHub) Off(ch <-chan *emitter.Event) error {
+	r.lk.Lock()
+	sub, ok := r.byCh[ch]
+	r.lk.Unlock()
+	if !ok {
+		return nil
+	}
+	r.drop(sub)
+	return r.request(context.Background(), &message{Type: msgUnsubscribe, ID: sub.id})
+}
+
+// Emit emits an event on the server's hub, and returns once the server acknowledged it
+// or ctx is done. The deadline of ctx, if any, is used by the server when emitting.
+//
+// When reconnection is enabled and the connection is down, the emit is buffered and sent
+// once reconnected. Emits that were sent but not acknowledged when the connection was
+// lost are sent again, so events may be delivered more than once.
+func (r *RemoteHub) Emit(ctx context.Context, topic string, args ...any) error {
+	return r.EmitEvent(ctx, topic, &emitter.Event{Args: args})
+}
+
+// EmitEvent is similar to [RemoteHub.Emit] but emits an existing event, including its
+// headers.
+func (r *RemoteHub) EmitEvent(ctx context.Context, topic string, ev *emitter.Event) error {
+	msg := &message{
+		Type:    msgEmit,
+		Topic:   topic,
+		Args:    ev.Args,
+		Header:  ev.Header,
+		EventID: ev.ID,
+		Timeout: timeoutOf(ctx),
+	}
+	return r.request(ctx, msg)
+}
+
+// Push sends a signal to the named trigger on the server. Pushes made while the connection
+// is down are lost.
+func (r *RemoteHub) Push(trigger string) error {
+	r.lk.Lock()
+	c := r.c
+	closed := r.closed
+	r.lk.Unlock()
+
+	if closed {
+		return ErrClosed
+	}
+	if c == nil {
+		return nil
+	}
+	return r.send(c, &message{Type: msgPush, Topic: trigger})
+}
+
+// Close closes the connection. All subscribed channels are closed.
+func (r *RemoteHub) Close() error {
+	r.lk.Lock()
+	r.closed = true
+	c := r.c
+	r.lk.Unlock()
+
+	r.cancel()
+	if c != nil {
+		c.Close()
+	}
+	return nil
+}
+
+// Dropped returns the number of events dropped because the queue of their subscription
+// was full, see [Options.QueueSize].
+func (r *RemoteHub) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
+// Done returns a channel that is closed once the client is closed, or the connection is
+// lost if reconnection is not enabled.
+func (r *RemoteHub) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *RemoteHub) send(c net.Conn, msg *message) error {
+	r.wlk.Lock()
+	defer r.wlk.Unlock()
+	return writeFrame(c, r.opts.Codec, msg)
+}
+
+// request sends msg with a new ID if it doesn't have one, and waits for the ack
+func (r *RemoteHub) request(ctx context.Context, msg *message) error {
+	req := &request{msg: msg, res: make(chan error, 1)}
+
+	r.lk.Lock()
+	if r.closed {
+		r.lk.Unlock()
+		return ErrClosed
+	}
+	if r.c == nil && len(r.pending) >= r.opts.BufferSize {
+		r.lk.Unlock()
+		return ErrBufferFull
+	}
+	if msg.ID == 0 {
+		r.nextID += 1
+		msg.ID = r.nextID
+	}
+	r.pending[msg.ID] = req
+	c := r.c
+	if c != nil {
+		req.gen = r.gen
+		if sub, ok := r.subs[msg.ID]; ok && msg.Type == msgSubscribe {
+			sub.gen = r.gen
+		}
+	}
+	r.lk.Unlock()
+
+	defer func() {
+		r.lk.Lock()
+		delete(r.pending, msg.ID)
+		r.lk.Unlock()
+	}()
+
+	if c != nil {
+		// if this fails, the request will be sent again once reconnected
+		r.send(c, msg)
+	}
+
+	select {
+	case err := <-req.res:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drop removes sub and closes its channel
+func (r *RemoteHub) drop(sub *remoteSub) {
+	r.lk.Lock()
+	_, ok := r.subs[sub.id]
+	delete(r.subs, sub.id)
+	delete(r.byCh, sub.ch)
+	r.lk.Unlock()
+
+	if ok {
+		sub.close()
+	}
+}
+
+// run reads from the connection, and reconnects when it is lost if enabled
+func (r *RemoteHub) run(c net.Conn) {
+	defer r.shutdown()
+
+	for {
+		r.read(c)
+
+		r.lk.Lock()
+		r.c = nil
+		stop := r.closed || !r.opts.Reconnect
+		r.lk.Unlock()
+		if stop {
+			return
+		}
+
+		c = r.reconnect()
+		if c == nil {
+			return
+		}
+	}
+}
+
+// reconnect establishes a new connection, restoring subscriptions and sending pending
+// requests. It returns nil if the client was closed meanwhile.
+func (r *RemoteHub) reconnect() net.Conn {
+	for attempt := 1; ; attempt += 1 {
+		select {
+		case <-time.After(r.opts.Backoff(attempt)):
+		case <-r.ctx.Done():
+			return nil
+		}
+
+		c, err := r.dial(r.ctx)
+		if err != nil {
+			continue
+		}
+
+		r.lk.Lock()
+		if r.closed {
+			r.lk.Unlock()
+			c.Close()
+			return nil
+		}
+		r.c = c
+		r.gen += 1
+
+		// collect everything that wasn't sent on this connection yet
+		var msgs []*message
+		for _, sub := range r.subs {
+			if sub.gen != r.gen {
+				sub.gen = r.gen
+				msgs = append(msgs, sub.message())
+			}
+		}
+		for _, req := range r.pending {
+			if req.gen != r.gen && req.msg.Type != msgSubscribe {
+				req.gen = r.gen
+				msgs = append(msgs, req.msg)
+			}
+		}
+		r.lk.Unlock()
+
+		slices.SortFunc(msgs, func(a, b *message) int {
+			return cmp.Compare(a.ID, b.ID)
+		})
+		for _, msg := range msgs {
+			if r.send(c, msg) != nil {
+				break
+			}
+		}
+		return c
+	}
+}
+
+func (r *RemoteHub) shutdown() {
+	r.lk.Lock()
+	r.closed = true
+	pending := r.pending
+	subs := r.subs
+	r.pending = make(map[uint64]*request)
+	r.subs = make(map[uint64]*remoteSub)
+	r.byCh = make(map[<-chan *emitter.Event]*remoteSub)
+	r.lk.Unlock()
+
+	r.cancel()
+	for _, req := range pending {
+		select {
+		case req.res <- ErrClosed:
+		default:
+			// already acknowledged
+		}
+	}
+	for _, sub := range subs {
+		sub.close()
+	}
+	close(r.done)
+}
+
+func (r *RemoteHub) read(c net.Conn) {
+	defer c.Close()
+
+	for {
+		msg, err := readFrame(c, r.opts.Codec)
+		if err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case msgAck:
+			r.lk.Lock()
+			req, ok := r.pending[msg.ID]
+			r.lk.Unlock()
+			if !ok {
+				continue
+			}
+			var err error
+			if msg.Error != "" {
+				err = errors.New(msg.Error)
+			}
+			select {
+			case req.res <- err:
+			default:
+				// already acknowledged, the request was sent twice
+			}
+		case msgEvent:
+			r.lk.Lock()
+			sub, ok := r.subs[msg.ID]
+			r.lk.Unlock()
+			if !ok {
+				continue
+			}
+			ev := &emitter.Event{
+				Context:      context.Background(),
+				Topic:        msg.Topic,
+				CurrentTopic: msg.Topic,
+				Args:         msg.Args,
+				Header:       msg.Header,
+				ID:           msg.EventID,
+			}
+			if !sub.deliver(ev, r.opts.QueueSize) {
+				r.dropped.Add(1)
+			}
+		}
+	}
+}
+
+func (sub *remoteSub) message() *message {
+	return &message{Type: msgSubscribe, ID: sub.id, Topic: sub.topic, Cap: sub.cap}
+}
+
+// deliver queues ev without blocking, and returns false if the queue is full
+func (sub *remoteSub) deliver(ev *emitter.Event, max int) bool {
+	sub.lk.Lock()
+	if len(sub.queue) >= max {
+		sub.lk.Unlock()
+		return false
+	}
+	sub.queue = append(sub.queue, ev)
+	sub.lk.Unlock()
+
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// next returns the next queued event, or nil if the queue is empty
+func (sub *remoteSub) next() *emitter.Event {
+	sub.lk.Lock()
+	defer sub.lk.Unlock()
+
+	if len(sub.queue) == 0 {
+		return nil
+	}
+	ev := sub.queue[0]
+	sub.queue[0] = nil
+	sub.queue = sub.queue[1:]
+	return ev
+}
+
+// run sends the queued events to ch until the subscription is closed
+func (sub *remoteSub) run() {
+	defer close(sub.ch)
+
+	for {
+		ev := sub.next()
+		if ev == nil {
+			select {
+			case <-sub.wake:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+
+		select {
+		case sub.ch <- ev:
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+func (sub *remoteSub) close() {
+	close(sub.done)
+}
@@ -0,0 +1,23 @@
+// Package ipc shares an [emitter.Hub] between local processes over a unix domain socket.
+//
+// The server side exposes an existing hub:
+//
+//	srv, err := ipc.Serve(hub, "/run/app.sock")
+//	defer srv.Close()
+//
+// Other processes connect to it and use it much like a local hub:
+//
+//	r, err := ipc.Dial("/run/app.sock")
+//	ch, err := r.On("topic")
+//	err = r.Emit(ctx, "topic", args...)
+//
+// Remote subscriptions are regular listeners on the server's hub, so emitting on the
+// server blocks until remote subscribers have accepted the event, as with local ones.
+// Clients queue the events of each subscription until they are read, up to
+// [Options.QueueSize], and servers perform the emits of a connection concurrently.
+//
+// Messages are sent as frames prefixed with their length as a 32 bits big endian
+// integer, and encoded with an [emitter.Codec], [emitter.JSONCodec] by default.
+// Arguments received from the remote side have the types produced by the codec,
+// and can be converted with [emitter.Arg].
+package ipc
@@ -0,0 +1,251 @@
+package ipc_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+	"github.com/KarpelesLab/emitter/ipc"
+)
+
+func setup(t *testing.T) (*emitter.Hub, *ipc.RemoteHub) {
+	h := emitter.New()
+	path := filepath.Join(t.TempDir(), "hub.sock")
+
+	srv, err := ipc.Serve(h, path)
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	r, err := ipc.Dial(path)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return h, r
+}
+
+func TestRemoteSubscribe(t *testing.T) {
+	h, r := setup(t)
+
+	ch, err := r.On("test")
+	if err != nil {
+		t.Fatalf("On failed: %v", err)
+	}
+
+	// the remote subscription is a regular listener on the server's hub
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ev := &emitter.Event{Args: []any{"hello", 42}, Header: map[string]string{"k": "v"}}
+	if err := h.EmitEvent(ctx, "test", ev); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+
+	select {
+	case ev := <-ch:
+		if ev.Topic != "test" {
+			t.Errorf("unexpected topic %s", ev.Topic)
+		}
+		if s, _ := emitter.Arg[string](ev, 0); s != "hello" {
+			t.Errorf("unexpected arg 0: %v", ev.Arg(0))
+		}
+		if n, _ := emitter.Arg[int](ev, 1); n != 42 {
+			t.Errorf("unexpected arg 1: %v", ev.Arg(1))
+		}
+		if ev.Header["k"] != "v" {
+			t.Errorf("missing header")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+
+	if err := r.Off(ch); err != nil {
+		t.Fatalf("Off failed: %v", err)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("expected channel to be closed")
+	}
+}
+
+func TestRemoteEmit(t *testing.T) {
+	h, r := setup(t)
+
+	local := h.OnWithCap("test", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := r.Emit(ctx, "test", "data"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	ev := <-local
+	if s, _ := emitter.Arg[string](ev, 0); s != "data" {
+		t.Errorf("unexpected arg: %v", ev.Arg(0))
+	}
+
+	if err := r.Emit(ctx, "nothing"); err == nil || err.Error() != emitter.ErrNoSuchTopic.Error() {
+		t.Errorf("expected no such topic error, got %v", err)
+	}
+
+	// the deadline is propagated to the server
+	_ = h.On("blocked")
+	short, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	if err := r.Emit(short, "blocked"); err == nil {
+		t.Error("expected emit on blocked topic to fail")
+	}
+}
+
+func TestRemotePattern(t *testing.T) {
+	h, r := setup(t)
+
+	ch, err := r.OnWithCap("user/{id}", 1)
+	if err != nil {
+		t.Fatalf("On failed: %v", err)
+	}
+	if err := h.EmitTimeout(time.Second, "user/42"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	if ev := <-ch; ev.Topic != "user/42" {
+		t.Errorf("unexpected topic %s", ev.Topic)
+	}
+}
+
+func TestRemotePush(t *testing.T) {
+	h, r := setup(t)
+
+	l := h.Trigger("trig").Listen()
+	defer l.Release()
+
+	if err := r.Push("trig"); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	select {
+	case <-l.C:
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for trigger")
+	}
+}
+
+func TestRemoteClose(t *testing.T) {
+	h, r := setup(t)
+
+	ch, err := r.On("test")
+	if err != nil {
+		t.Fatalf("On failed: %v", err)
+	}
+	r.Close()
+
+	if _, ok := <-ch; ok {
+		t.Error("expected channel to be closed")
+	}
+	<-r.Done()
+
+	if _, err := r.On("test"); err != ipc.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	// the server must eventually drop the remote listener
+	deadline := time.Now().Add(time.Second)
+	for h.EmitTimeout(10*time.Millisecond, "test") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("remote listener was not removed")
+		}
+	}
+}
+
+func TestRemoteEventID(t *testing.T) {
+	h, r := setup(t)
+	h.SetDedup("orders", &emitter.DedupOptions{})
+	local := h.OnWithCap("orders", 2)
+
+	ch, err := r.On("orders")
+	if err != nil {
+		t.Fatalf("On failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// the ID is sent with emits and events
+	if err := r.EmitEvent(ctx, "orders", &emitter.Event{ID: "o1"}); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	select {
+	case ev := <-ch:
+		if ev.ID != "o1" {
+			t.Errorf("unexpected ID %q", ev.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+
+	if err := r.EmitEvent(ctx, "orders", &emitter.Event{ID: "o1"}); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	if len(local) != 1 || h.DedupHits("orders") != 1 {
+		t.Errorf("expected duplicate to be dropped, got %d events", len(local))
+	}
+}
+
+func TestRemoteSlowSubscriber(t *testing.T) {
+	h, r := setup(t)
+
+	// a subscription which is not read does not block the others
+	r.On("slow")
+	fast, _ := r.On("fast")
+	for range 2 {
+		// the second emit returns once the first event was sent to the client
+		h.EmitTimeout(time.Second, "slow")
+	}
+	go h.EmitTimeout(time.Second, "fast")
+	select {
+	case <-fast:
+	case <-time.After(time.Second):
+		t.Fatal("event blocked by another subscription")
+	}
+
+	// a remote emit waiting for a listener does not block the next ones
+	h.On("blocked")
+	local := h.OnWithCap("local", 1)
+	go r.Emit(context.Background(), "blocked")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.Emit(ctx, "local"); err != nil {
+		t.Fatalf("emit blocked by another emit: %v", err)
+	}
+	if len(local) != 1 {
+		t.Error("event was not delivered")
+	}
+}
+
+func TestRemoteQueueFull(t *testing.T) {
+	h := emitter.New()
+	path := filepath.Join(t.TempDir(), "hub.sock")
+	srv, _ := ipc.Serve(h, path)
+	defer srv.Close()
+	r, err := ipc.NewClient(func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}, ipc.Options{QueueSize: 1})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer r.Close()
+
+	// at most one event is waiting to be read and one is queued, the others are dropped
+	r.On("test")
+	for range 4 {
+		h.EmitTimeout(time.Second, "test")
+	}
+	deadline := time.Now().Add(time.Second)
+	for r.Dropped() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected dropped events, got %d", r.Dropped())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,95 @@
+package ipc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// MaxFrameSize is the maximum size of a single frame. Larger frames cause the
+// connection to be closed.
+const MaxFrameSize = 16 << 20
+
+// message types
+const (
+	msgSubscribe   = "sub"
+	msgUnsubscribe = "unsub"
+	msgEmit        = "emit"
+	msgPush        = "push"
+	msgAck         = "ack"
+	msgEvent       = "event"
+)
+
+// message is the unit of the protocol. Each message is encoded with the connection's
+// codec and sent as a frame prefixed with its length as a 32 bits big endian integer.
+//
+// Requests (sub, unsub, emit) carry an ID that is returned in the matching ack. For
+// sub, the ID also identifies the subscription in subsequent event messages.
+type message struct {
+	Type    string            `json:"t"`
+	ID      uint64            `json:"id,omitempty"`
+	Topic   string            `json:"topic,omitempty"`
+	Args    []any             `json:"args,omitempty"`
+	Header  map[string]string `json:"hdr,omitempty"`
+	EventID string            `json:"eid,omitempty"`     // emit, event: see emitter.Event.ID
+	Cap     uint              `json:"cap,omitempty"`     // sub: listener capacity on the server
+	Timeout int64             `json:"timeout,omitempty"` // emit: deadline in milliseconds
+	Error   string            `json:"err,omitempty"`     // ack: error, if any
+}
+
+// ErrFrameTooLarge is returned when receiving a frame larger than [MaxFrameSize].
+var ErrFrameTooLarge = errors.New("ipc: frame too large")
+
+func writeFrame(w io.Writer, codec emitter.Codec, msg *message) error {
+	buf, err := codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(buf) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	frame := make([]byte, 4+len(buf))
+	binary.BigEndian.PutUint32(frame, uint32(len(buf)))
+	copy(frame[4:], buf)
+	_, err = w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader, codec emitter.Codec) (*message, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	ln := binary.BigEndian.Uint32(hdr[:])
+	if ln > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	buf := make([]byte, ln)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := codec.Unmarshal(buf, msg); err != nil {
+		return nil, fmt.Errorf("ipc: invalid frame: %w", err)
+	}
+	return msg, nil
+}
+
+// timeoutOf returns the remaining time before the deadline of ctx, in milliseconds,
+// or zero if ctx has no deadline
+func timeoutOf(ctx context.Context) int64 {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	ms := time.Until(deadline).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
@@ -0,0 +1,269 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// DefaultEmitTimeout is the timeout used for emits requested by clients that did not
+// specify a deadline.
+const DefaultEmitTimeout = 30 * time.Second
+
+// Server exposes a [emitter.Hub] to remote clients. Remote subscriptions are regular
+// listeners on the hub's topics, and remote emits are regular emits.
+type Server struct {
+	// Codec is used to encode messages, and defaults to [emitter.JSONCodec]. It must
+	// be set before calling [Server.Serve] and match the codec used by clients.
+	Codec emitter.Codec
+
+	// MaxInFlight is the maximum number of emits performed at the same time for each
+	// connection, and defaults to [emitter.DefaultMaxInFlight]. Once reached, no more
+	// requests are read from the connection until an emit completes.
+	MaxInFlight int
+
+	hub   *emitter.Hub
+	lk    sync.Mutex
+	ls    map[net.Listener]bool
+	conns map[*serverConn]bool
+	done  bool
+}
+
+// NewServer returns a new server for the given hub.
+func NewServer(hub *emitter.Hub) *Server {
+	return &Server{
+		hub:   hub,
+		ls:    make(map[net.Listener]bool),
+		conns: make(map[*serverConn]bool),
+	}
+}
+
+// Serve listens on the unix domain socket at path and serves the hub in the background.
+// A stale socket file at path is removed first.
+func Serve(hub *emitter.Hub, path string) (*Server, error) {
+	if st, err := os.Stat(path); err == nil && st.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	srv := NewServer(hub)
+	go srv.Serve(l)
+	return srv, nil
+}
+
+// Serve accepts connections on l until l is closed or the server is closed. It always
+// returns a non-nil error.
+func (srv *Server) Serve(l net.Listener) error {
+	srv.lk.Lock()
+	if srv.done {
+		srv.lk.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	srv.ls[l] = true
+	srv.lk.Unlock()
+
+	defer func() {
+		srv.lk.Lock()
+		delete(srv.ls, l)
+		srv.lk.Unlock()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeConn(c)
+	}
+}
+
+// ServeConn serves a single connection, and returns once it is closed.
+func (srv *Server) ServeConn(c net.Conn) {
+	sc := &serverConn{
+		srv:   srv,
+		c:     c,
+		codec: srv.Codec,
+		subs:  make(map[uint64]<-chan *emitter.Event),
+	}
+	if sc.codec == nil {
+		sc.codec = emitter.JSONCodec
+	}
+	inFlight := srv.MaxInFlight
+	if inFlight <= 0 {
+		inFlight = emitter.DefaultMaxInFlight
+	}
+	sc.sem = make(chan struct{}, inFlight)
+
+	srv.lk.Lock()
+	if srv.done {
+		srv.lk.Unlock()
+		c.Close()
+		return
+	}
+	srv.conns[sc] = true
+	srv.lk.Unlock()
+
+	sc.run()
+
+	srv.lk.Lock()
+	delete(srv.conns, sc)
+	srv.lk.Unlock()
+}
+
+// Close stops all listeners and closes all connections.
+func (srv *Server) Close() error {
+	srv.lk.Lock()
+	srv.done = true
+	ls := srv.ls
+	conns := srv.conns
+	srv.ls = make(map[net.Listener]bool)
+	srv.conns = make(map[*serverConn]bool)
+	srv.lk.Unlock()
+
+	for l := range ls {
+		l.Close()
+	}
+	for sc := range conns {
+		sc.c.Close()
+	}
+	return nil
+}
+
+type serverConn struct {
+	srv   *Server
+	c     net.Conn
+	codec emitter.Codec
+	wlk   sync.Mutex // write lock
+	subs  map[uint64]<-chan *emitter.Event
+	subLk sync.Mutex
+	sem   chan struct{} // limits the number of emits in flight
+}
+
+func (sc *serverConn) run() {
+	defer sc.cleanup()
+
+	for {
+		msg, err := readFrame(sc.c, sc.codec)
+		if err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case msgSubscribe:
+			sc.ack(msg.ID, sc.subscribe(msg))
+		case msgUnsubscribe:
+			sc.unsubscribe(msg.ID)
+			sc.ack(msg.ID, nil)
+		case msgEmit:
+			// emits run in the background, as they may wait for subscribers of this
+			// connection, and are acknowledged by ID in any order
+			sc.sem <- struct{}{}
+			go func() {
+				defer func() { <-sc.sem }()
+				sc.ack(msg.ID, sc.emit(msg))
+			}()
+		case msgPush:
+			sc.srv.hub.Push(msg.Topic)
+		default:
+			sc.ack(msg.ID, errors.New("unsupported message type"))
+		}
+	}
+}
+
+func (sc *serverConn) send(msg *message) error {
+	sc.wlk.Lock()
+	defer sc.wlk.Unlock()
+	return writeFrame(sc.c, sc.codec, msg)
+}
+
+func (sc *serverConn) ack(id uint64, err error) {
+	msg := &message{Type: msgAck, ID: id}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	sc.send(msg)
+}
+
+func (sc *serverConn) subscribe(msg *message) error {
+	sc.subLk.Lock()
+	defer sc.subLk.Unlock()
+
+	if _, ok := sc.subs[msg.ID]; ok {
+		return errors.New("subscription already exists")
+	}
+
+	var ch <-chan *emitter.Event
+	if emitter.IsPattern(msg.Topic) {
+		var err error
+		ch, err = sc.srv.hub.OnPattern(msg.Topic)
+		if err != nil {
+			return err
+		}
+	} else {
+		ch = sc.srv.hub.OnWithCap(msg.Topic, msg.Cap)
+	}
+	sc.subs[msg.ID] = ch
+
+	go sc.forward(msg.ID, ch)
+	return nil
+}
+
+// forward sends events received on ch to the remote side until ch is closed
+func (sc *serverConn) forward(id uint64, ch <-chan *emitter.Event) {
+	for ev := range ch {
+		err := sc.send(&message{Type: msgEvent, ID: id, Topic: ev.Topic, Args: ev.Args, Header: ev.Header, EventID: ev.ID})
+		if err != nil {
+			// connection is dead, cleanup will unsubscribe
+			sc.c.Close()
+		}
+	}
+}
+
+func (sc *serverConn) unsubscribe(id uint64) {
+	sc.subLk.Lock()
+	ch, ok := sc.subs[id]
+	delete(sc.subs, id)
+	sc.subLk.Unlock()
+
+	if ok {
+		sc.srv.hub.Unsubscribe(ch)
+	}
+}
+
+func (sc *serverConn) emit(msg *message) error {
+	timeout := DefaultEmitTimeout
+	if msg.Timeout > 0 {
+		timeout = time.Duration(msg.Timeout) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ev := &emitter.Event{
+		Context: ctx,
+		Args:    msg.Args,
+		Header:  msg.Header,
+		ID:      msg.EventID,
+	}
+	return sc.srv.hub.EmitEvent(ctx, msg.Topic, ev)
+}
+
+func (sc *serverConn) cleanup() {
+	sc.c.Close()
+
+	sc.subLk.Lock()
+	subs := sc.subs
+	sc.subs = nil
+	sc.subLk.Unlock()
+
+	for _, ch := range subs {
+		sc.srv.hub.Unsubscribe(ch)
+	}
+}
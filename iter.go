@@ -0,0 +1,83 @@
+package emitter
+
+import (
+	"context"
+	"iter"
+)
+
+// Events returns an iterator over the events emitted on the given topic. The
+// subscription is created when iteration starts and removed with [Hub.Off] as
+// soon as the loop exits, whether because of a break, the context being done
+// or the topic being closed.
+//
+// Example:
+//
+//	for ev := range h.Events(ctx, "topic") {
+//	    // process event...
+//	}
+func (h *Hub) Events(ctx context.Context, topic string) iter.Seq[*Event] {
+	return func(yield func(*Event) bool) {
+		for _, ev := range h.IndexedEvents(ctx, topic) {
+			if !yield(ev) {
+				return
+			}
+		}
+	}
+}
+
+// IndexedEvents is similar to [Hub.Events] but also yields a sequence number for
+// each event, starting at zero.
+func (h *Hub) IndexedEvents(ctx context.Context, topic string) iter.Seq2[int, *Event] {
+	return func(yield func(int, *Event) bool) {
+		ch := h.On(topic)
+		defer func() {
+			// an emitter may be blocked on ch, keep draining it until Off closes it
+			go h.Off(topic, ch)
+			for range ch {
+			}
+		}()
+
+		for n := 0; ; n += 1 {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				if !yield(n, ev) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Signals returns an iterator yielding once for each signal received by the
+// trigger t. The listener is released when the loop exits.
+//
+// Example:
+//
+//	for range emitter.Signals(ctx, trig) {
+//	    // handle trigger
+//	}
+func Signals(ctx context.Context, t Trigger) iter.Seq[struct{}] {
+	return func(yield func(struct{}) bool) {
+		l := t.Listen()
+		defer l.Release()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-l.C:
+				if !ok {
+					return
+				}
+				if !yield(struct{}{}) {
+					return
+				}
+			}
+		}
+	}
+}
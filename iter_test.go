@@ -0,0 +1,134 @@
+package emitter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func TestEventsIterator(t *testing.T) {
+	h := emitter.New()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			for h.Emit(ctx, "test", i) == emitter.ErrNoSuchTopic {
+				// wait for the iterator to subscribe
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	n := 0
+	for ev := range h.Events(ctx, "test") {
+		v, err := emitter.Arg[int](ev, 0)
+		if err != nil {
+			t.Errorf("failed to get arg: %v", err)
+		}
+		if v != n {
+			t.Errorf("unexpected value %d, expected %d", v, n)
+		}
+		n += 1
+		if n == 3 {
+			break
+		}
+	}
+
+	// breaking out of the loop should have removed the listener
+	err := h.EmitTimeout(50*time.Millisecond, "test", 42)
+	if err != nil {
+		t.Errorf("expected emit without listeners to succeed, got %v", err)
+	}
+}
+
+func TestIndexedEventsIterator(t *testing.T) {
+	h := emitter.New()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			for h.Emit(ctx, "test", "x") == emitter.ErrNoSuchTopic {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	expect := 0
+	for n, ev := range h.IndexedEvents(ctx, "test") {
+		if n != expect {
+			t.Errorf("unexpected sequence number %d, expected %d", n, expect)
+		}
+		if ev.Topic != "test" {
+			t.Errorf("unexpected topic: %s", ev.Topic)
+		}
+		expect += 1
+		if expect == 5 {
+			break
+		}
+	}
+}
+
+func TestEventsIteratorContext(t *testing.T) {
+	h := emitter.New()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	for range h.Events(ctx, "test") {
+		t.Error("unexpected event")
+	}
+}
+
+func TestEventsIteratorTopicClosed(t *testing.T) {
+	h := emitter.New()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		h.Close()
+	}()
+
+	for range h.Events(context.Background(), "test") {
+		t.Error("unexpected event")
+	}
+}
+
+func TestSignalsIterator(t *testing.T) {
+	trig := emitter.NewTrigger()
+	defer trig.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		for ctx.Err() == nil {
+			trig.Push()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	n := 0
+	for range emitter.Signals(ctx, trig) {
+		n += 1
+		if n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("expected 3 signals, got %d", n)
+	}
+}
+
+func TestSignalsIteratorClose(t *testing.T) {
+	trig := emitter.NewTrigger()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		trig.Close()
+	}()
+
+	for range emitter.Signals(context.Background(), trig) {
+	}
+}
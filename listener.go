@@ -1,16 +1,61 @@
 package emitter
 
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// lastListenerID is used to assign listener identifiers
+var lastListenerID atomic.Uint64
+
 type listener struct {
-	ch chan *Event
+	id     uint64
+	ch     chan *Event
+	refs   int32             // number of topics this listener is attached to
+	filter func(*Event) bool // if not nil, only events for which filter returns true are delivered
+
+	// push, if not nil, is called synchronously by emit instead of sending events to ch,
+	// which is then only used to identify the listener
+	push func(context.Context, *Event) error
+
+	breaker breaker // circuit breaker state, see Hub.Breaker
+
+	// stats, if not nil, completes the statistics of listeners backed by a mailbox
+	stats func(*ListenerStats)
 }
 
 func newListener(c uint) *listener {
 	res := &listener{
+		id: lastListenerID.Add(1),
 		ch: make(chan *Event, c),
 	}
 	return res
 }
 
+// accept returns true if ev should be delivered to this listener. A panic in the filter
+// is returned as an error, and the event is not delivered.
+func (l *listener) accept(ev *Event) (ok bool, err error) {
+	if l.filter == nil {
+		return true, nil
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			ok = false
+			err = fmt.Errorf("%w on topic %s: %v", ErrFilterPanic, ev.Topic, e)
+		}
+	}()
+	return l.filter(ev), nil
+}
+
+// release is called each time the listener is detached from a topic, and closes it
+// once it isn't attached to any topic anymore
+func (l *listener) release() {
+	if atomic.AddInt32(&l.refs, -1) == 0 {
+		go l.close()
+	}
+}
+
 func (l *listener) close() {
 	if l.ch != nil {
 		close(l.ch)
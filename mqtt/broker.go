@@ -0,0 +1,241 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+	"github.com/KarpelesLab/emitter/internal/ws"
+)
+
+// Headers set on events published by MQTT clients.
+const (
+	HeaderClientID = "Mqtt-Client-Id"
+	HeaderQoS      = "Mqtt-Qos"
+)
+
+const (
+	// DefaultEmitTimeout is the maximum time spent delivering a message published by
+	// a client to the hub's listeners.
+	DefaultEmitTimeout = 30 * time.Second
+
+	// DefaultWriteTimeout is the maximum time spent sending a packet to a client
+	// before it is disconnected.
+	DefaultWriteTimeout = 30 * time.Second
+
+	connectTimeout = 10 * time.Second
+)
+
+// Broker is an MQTT broker backed by a [emitter.Hub]. Messages published by clients are
+// emitted on the hub, and client subscriptions are regular listeners on the hub, so
+// clients receive events emitted by MQTT clients and local code alike.
+type Broker struct {
+	// MaxPacketSize is the maximum size of packets accepted from clients, and defaults
+	// to [DefaultMaxPacketSize].
+	MaxPacketSize int
+
+	// EmitTimeout is the maximum time spent emitting a published message on the hub,
+	// and defaults to [DefaultEmitTimeout].
+	EmitTimeout time.Duration
+
+	// Auth, if set, is called when a client connects, and the connection is refused if
+	// it returns false. username and password are empty if not provided.
+	Auth func(clientID, username string, password []byte) bool
+
+	hub      *emitter.Hub
+	lk       sync.Mutex
+	ls       map[net.Listener]bool
+	conns    map[*conn]bool
+	clients  map[string]*conn
+	retained map[string]*message
+	done     bool
+}
+
+// message is an application message, as published or retained
+type message struct {
+	topic    string
+	payload  []byte
+	qos      byte
+	retain   bool
+	clientID string
+}
+
+// NewBroker returns a new broker for the given hub.
+func NewBroker(hub *emitter.Hub) *Broker {
+	return &Broker{
+		hub:      hub,
+		ls:       make(map[net.Listener]bool),
+		conns:    make(map[*conn]bool),
+		clients:  make(map[string]*conn),
+		retained: make(map[string]*message),
+	}
+}
+
+// Serve accepts MQTT connections on l until l is closed or the broker is closed. It
+// always returns a non-nil error.
+func (b *Broker) Serve(l net.Listener) error {
+	b.lk.Lock()
+	if b.done {
+		b.lk.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	b.ls[l] = true
+	b.lk.Unlock()
+
+	defer func() {
+		b.lk.Lock()
+		delete(b.ls, l)
+		b.lk.Unlock()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go b.ServeConn(c)
+	}
+}
+
+// ServeHTTP serves MQTT over WebSocket, using the "mqtt" subprotocol.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := ws.Upgrade(w, r, []string{"mqtt"})
+	if err != nil {
+		return
+	}
+	b.ServeConn(c.NetConn())
+}
+
+// ServeConn serves a single client connection, and returns once it is closed.
+func (b *Broker) ServeConn(c net.Conn) {
+	cn := &conn{
+		b:    b,
+		c:    c,
+		subs: make(map[string]*subscription),
+	}
+
+	b.lk.Lock()
+	if b.done {
+		b.lk.Unlock()
+		c.Close()
+		return
+	}
+	b.conns[cn] = true
+	b.lk.Unlock()
+
+	cn.run()
+
+	b.lk.Lock()
+	delete(b.conns, cn)
+	if b.clients[cn.id] == cn {
+		delete(b.clients, cn.id)
+	}
+	b.lk.Unlock()
+}
+
+// Close stops all listeners and disconnects all clients. Last will messages of
+// connected clients are published.
+func (b *Broker) Close() error {
+	b.lk.Lock()
+	b.done = true
+	ls := b.ls
+	conns := b.conns
+	b.ls = make(map[net.Listener]bool)
+	b.conns = make(map[*conn]bool)
+	b.lk.Unlock()
+
+	for l := range ls {
+		l.Close()
+	}
+	for cn := range conns {
+		cn.c.Close()
+	}
+	return nil
+}
+
+// register makes cn the connection for its client id, disconnecting any previous
+// connection using the same id.
+func (b *Broker) register(cn *conn) {
+	b.lk.Lock()
+	prev := b.clients[cn.id]
+	b.clients[cn.id] = cn
+	b.lk.Unlock()
+
+	if prev != nil {
+		prev.c.Close()
+	}
+}
+
+// publish stores the message if it is retained, and emits it on the hub
+func (b *Broker) publish(msg *message) error {
+	if msg.retain {
+		b.lk.Lock()
+		if len(msg.payload) == 0 {
+			delete(b.retained, msg.topic)
+		} else {
+			b.retained[msg.topic] = msg
+		}
+		b.lk.Unlock()
+	}
+
+	timeout := b.EmitTimeout
+	if timeout <= 0 {
+		timeout = DefaultEmitTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ev := &emitter.Event{
+		Context: ctx,
+		Args:    []any{msg.payload},
+		Header: map[string]string{
+			HeaderClientID: msg.clientID,
+			HeaderQoS:      strconv.Itoa(int(msg.qos)),
+		},
+	}
+	err := b.hub.EmitEvent(ctx, msg.topic, ev)
+	if err == emitter.ErrNoSuchTopic {
+		// nobody is listening
+		return nil
+	}
+	return err
+}
+
+// retainedFor returns the retained messages matching filter
+func (b *Broker) retainedFor(filter string) []*message {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	var res []*message
+	for topic, msg := range b.retained {
+		if matchFilter(filter, topic) {
+			res = append(res, msg)
+		}
+	}
+	return res
+}
+
+// payloadOf returns the MQTT payload for an event. A single []byte or string argument
+// is sent as is, other arguments are encoded as JSON.
+func payloadOf(ev *emitter.Event) ([]byte, error) {
+	switch len(ev.Args) {
+	case 0:
+		return nil, nil
+	case 1:
+		switch v := ev.Args[0].(type) {
+		case []byte:
+			return v, nil
+		case string:
+			return []byte(v), nil
+		}
+		return ev.EncodedArg(0, "json", json.Marshal)
+	default:
+		return json.Marshal(ev.Args)
+	}
+}
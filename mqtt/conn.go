@@ -0,0 +1,522 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// CONNACK return codes
+const (
+	connackAccepted           = 0x00
+	connackBadVersion         = 0x01 // MQTT 3.1.1
+	connackBadClientID        = 0x02 // MQTT 3.1.1
+	connackNotAuthorized      = 0x05 // MQTT 3.1.1
+	connackUnsupportedVersion = 0x84 // MQTT 5
+	connackNotAuthorized5     = 0x87 // MQTT 5
+)
+
+const (
+	reasonUnspecified     = 0x80
+	reasonNoSubscription  = 0x11
+	reasonDisconnectWill  = 0x04
+	reasonQoSNotSupported = 0x9b
+)
+
+var errProtocol = errors.New("mqtt: protocol violation")
+
+type conn struct {
+	b       *Broker
+	c       net.Conn
+	br      *bufio.Reader
+	version byte
+	id      string
+	will    *message
+	wlk     sync.Mutex // write lock, also protects lastID
+	lastID  uint16
+	subs    map[string]*subscription
+	subLk   sync.Mutex
+	pubrec  map[uint16]bool // QoS 2 messages received and awaiting PUBREL, see handlePublish
+}
+
+type subscription struct {
+	ch      <-chan *emitter.Event
+	qos     byte
+	noLocal bool
+}
+
+func (cn *conn) run() {
+	defer cn.cleanup()
+
+	cn.br = bufio.NewReader(cn.c)
+	keepAlive, err := cn.connect()
+	if err != nil {
+		return
+	}
+
+	for {
+		if keepAlive > 0 {
+			cn.c.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		}
+		p, err := readPacket(cn.br, cn.maxPacketSize())
+		if err != nil {
+			return
+		}
+
+		switch p.typ {
+		case typePublish:
+			err = cn.handlePublish(p)
+		case typePuback:
+			// messages are not redelivered, so acknowledgements need no tracking
+		case typePubrel:
+			err = cn.handlePubrel(p)
+		case typeSubscribe:
+			err = cn.handleSubscribe(p)
+		case typeUnsubscribe:
+			err = cn.handleUnsubscribe(p)
+		case typePingreq:
+			err = cn.write(typePingresp, 0, nil)
+		case typeDisconnect:
+			d := &decoder{buf: p.body}
+			if cn.version < version5 || len(p.body) == 0 || d.byte() != reasonDisconnectWill {
+				// normal disconnection, the will is discarded
+				cn.will = nil
+			}
+			return
+		default:
+			err = errProtocol
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (cn *conn) maxPacketSize() int {
+	if cn.b.MaxPacketSize > 0 {
+		return cn.b.MaxPacketSize
+	}
+	return DefaultMaxPacketSize
+}
+
+func (cn *conn) write(typ, flags byte, body []byte) error {
+	cn.wlk.Lock()
+	defer cn.wlk.Unlock()
+	return cn.writeLocked(typ, flags, body)
+}
+
+func (cn *conn) writeLocked(typ, flags byte, body []byte) error {
+	cn.c.SetWriteDeadline(time.Now().Add(DefaultWriteTimeout))
+	err := writePacket(cn.c, typ, flags, body)
+	if err != nil {
+		// connection is dead, run will cleanup
+		cn.c.Close()
+	}
+	return err
+}
+
+// connect handles the CONNECT packet, and returns the keep alive interval
+func (cn *conn) connect() (time.Duration, error) {
+	cn.c.SetReadDeadline(time.Now().Add(connectTimeout))
+	p, err := readPacket(cn.br, cn.maxPacketSize())
+	if err != nil {
+		return 0, err
+	}
+	cn.c.SetReadDeadline(time.Time{})
+	if p.typ != typeConnect {
+		return 0, errProtocol
+	}
+
+	d := &decoder{buf: p.body}
+	proto := d.string()
+	cn.version = d.byte()
+	if d.err != nil || proto != "MQTT" {
+		return 0, errProtocol
+	}
+	if cn.version != version311 && cn.version != version5 {
+		code := byte(connackBadVersion)
+		if cn.version > version5 {
+			code = connackUnsupportedVersion
+		}
+		cn.version = version311
+		cn.connack(code, "")
+		return 0, errProtocol
+	}
+
+	flags := d.byte()
+	keepAlive := time.Duration(d.uint16()) * time.Second
+	if cn.version >= version5 {
+		d.properties()
+	}
+	cn.id = d.string()
+
+	if flags&0x04 != 0 {
+		will := &message{
+			qos:    min((flags>>3)&0x03, 1),
+			retain: flags&0x20 != 0,
+		}
+		if cn.version >= version5 {
+			d.properties()
+		}
+		will.topic = d.string()
+		will.payload = d.binary()
+		if !validTopic(will.topic) {
+			return 0, errInvalidTopic
+		}
+		cn.will = will
+	}
+
+	var username string
+	var password []byte
+	if flags&0x80 != 0 {
+		username = d.string()
+	}
+	if flags&0x40 != 0 {
+		password = d.binary()
+	}
+	if d.err != nil || flags&0x01 != 0 {
+		return 0, errProtocol
+	}
+
+	var assigned string
+	if cn.id == "" {
+		if cn.version < version5 && flags&0x02 == 0 {
+			// MQTT 3.1.1 requires a client id to resume a session
+			cn.connack(connackBadClientID, "")
+			return 0, errProtocol
+		}
+		buf := make([]byte, 12)
+		rand.Read(buf)
+		cn.id = "auto-" + hex.EncodeToString(buf)
+		assigned = cn.id
+	}
+	if cn.will != nil {
+		cn.will.clientID = cn.id
+	}
+
+	if cn.b.Auth != nil && !cn.b.Auth(cn.id, username, password) {
+		code := byte(connackNotAuthorized)
+		if cn.version >= version5 {
+			code = connackNotAuthorized5
+		}
+		cn.will = nil
+		cn.connack(code, "")
+		return 0, errProtocol
+	}
+
+	// sessions are not persisted, so session present is always 0
+	cn.b.register(cn)
+	return keepAlive, cn.connack(connackAccepted, assigned)
+}
+
+func (cn *conn) connack(code byte, assigned string) error {
+	e := &encoder{}
+	e.byte(0) // session present
+	e.byte(code)
+	if cn.version >= version5 {
+		props := &encoder{}
+		// maximum QoS
+		props.byte(0x24)
+		props.byte(1)
+		if assigned != "" {
+			// assigned client identifier
+			props.byte(0x12)
+			props.string(assigned)
+		}
+		e.buf = appendVarint(e.buf, len(props.buf))
+		e.buf = append(e.buf, props.buf...)
+	}
+	return cn.write(typeConnack, 0, e.buf)
+}
+
+func (cn *conn) handlePublish(p *packet) error {
+	msg := &message{
+		qos:      (p.flags >> 1) & 0x03,
+		retain:   p.flags&0x01 != 0,
+		clientID: cn.id,
+	}
+	if msg.qos > 1 && cn.version >= version5 {
+		// the CONNACK announced a maximum QoS of 1
+		e := &encoder{}
+		e.byte(reasonQoSNotSupported)
+		cn.write(typeDisconnect, 0, e.buf)
+		return errProtocol
+	}
+
+	d := &decoder{buf: p.body}
+	msg.topic = d.string()
+	var id uint16
+	if msg.qos > 0 {
+		id = d.uint16()
+	}
+	if cn.version >= version5 {
+		d.properties()
+	}
+	msg.payload = d.rest()
+	if d.err != nil {
+		return d.err
+	}
+	if !validTopic(msg.topic) {
+		return errInvalidTopic
+	}
+	if msg.qos == 2 {
+		return cn.publishExactlyOnce(msg, id)
+	}
+
+	err := cn.b.publish(msg)
+	if msg.qos == 0 {
+		return nil
+	}
+
+	e := &encoder{}
+	e.uint16(id)
+	if err != nil && cn.version >= version5 {
+		e.byte(reasonUnspecified)
+	}
+	return cn.write(typePuback, 0, e.buf)
+}
+
+// publishExactlyOnce publishes a QoS 2 message of a MQTT 3.1.1 client as a QoS 1 one.
+// Its packet identifier is kept until PUBREL, so retransmissions are not published again.
+func (cn *conn) publishExactlyOnce(msg *message, id uint16) error {
+	if !cn.pubrec[id] {
+		msg.qos = 1
+		// MQTT 3.1.1 has no way to report a failure, as with QoS 1
+		cn.b.publish(msg)
+		if cn.pubrec == nil {
+			cn.pubrec = make(map[uint16]bool)
+		}
+		cn.pubrec[id] = true
+	}
+
+	e := &encoder{}
+	e.uint16(id)
+	return cn.write(typePubrec, 0, e.buf)
+}
+
+func (cn *conn) handlePubrel(p *packet) error {
+	if p.flags != 0x02 {
+		return errProtocol
+	}
+	d := &decoder{buf: p.body}
+	id := d.uint16()
+	if d.err != nil {
+		return d.err
+	}
+	delete(cn.pubrec, id)
+
+	e := &encoder{}
+	e.uint16(id)
+	return cn.write(typePubcomp, 0, e.buf)
+}
+
+func (cn *conn) handleSubscribe(p *packet) error {
+	if p.flags != 0x02 {
+		return errProtocol
+	}
+	d := &decoder{buf: p.body}
+	id := d.uint16()
+	if cn.version >= version5 {
+		d.properties()
+	}
+
+	type request struct {
+		filter string
+		opts   byte
+	}
+	var reqs []request
+	for len(d.buf) > 0 {
+		filter := d.string()
+		opts := d.byte()
+		if d.err != nil {
+			return d.err
+		}
+		if opts&0x03 > 2 {
+			return errProtocol
+		}
+		reqs = append(reqs, request{filter, opts})
+	}
+	if len(reqs) == 0 {
+		return errProtocol
+	}
+
+	e := &encoder{}
+	e.uint16(id)
+	e.properties(cn.version)
+	var retained []*message
+	for _, req := range reqs {
+		qos := min(req.opts&0x03, 1)
+		isNew, err := cn.subscribe(req.filter, qos, cn.version >= version5 && req.opts&0x04 != 0)
+		if err != nil {
+			e.byte(reasonUnspecified)
+			continue
+		}
+		e.byte(qos)
+
+		// MQTT 5 retain handling: 0 always sends retained messages, 1 only for new
+		// subscriptions, 2 never
+		if handling := (req.opts >> 4) & 0x03; cn.version < version5 || handling == 0 || (handling == 1 && isNew) {
+			for _, msg := range cn.b.retainedFor(req.filter) {
+				retained = append(retained, &message{
+					topic:   msg.topic,
+					payload: msg.payload,
+					qos:     min(msg.qos, qos),
+					retain:  true,
+				})
+			}
+		}
+	}
+	if err := cn.write(typeSuback, 0, e.buf); err != nil {
+		return err
+	}
+	for _, msg := range retained {
+		if err := cn.send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribe attaches a listener for the given filter, or updates the options of the
+// existing subscription. It returns true if the subscription is new.
+func (cn *conn) subscribe(filter string, qos byte, noLocal bool) (bool, error) {
+	topic, err := filterToPattern(filter)
+	if err != nil {
+		return false, err
+	}
+
+	cn.subLk.Lock()
+	defer cn.subLk.Unlock()
+
+	if sub, ok := cn.subs[filter]; ok {
+		sub.qos = qos
+		sub.noLocal = noLocal
+		return false, nil
+	}
+
+	var ch <-chan *emitter.Event
+	if emitter.IsPattern(topic) {
+		ch, err = cn.b.hub.OnPattern(topic)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		ch = cn.b.hub.On(topic)
+	}
+	sub := &subscription{ch: ch, qos: qos, noLocal: noLocal}
+	cn.subs[filter] = sub
+
+	go cn.forward(sub)
+	return true, nil
+}
+
+// forward sends events received by sub to the client until its channel is closed
+func (cn *conn) forward(sub *subscription) {
+	for ev := range sub.ch {
+		cn.subLk.Lock()
+		qos, noLocal := sub.qos, sub.noLocal
+		cn.subLk.Unlock()
+
+		if noLocal && ev.Header[HeaderClientID] == cn.id {
+			continue
+		}
+		if v, ok := ev.Header[HeaderQoS]; ok {
+			if n, err := strconv.Atoi(v); err == nil && n < int(qos) {
+				qos = byte(n)
+			}
+		}
+		payload, err := payloadOf(ev)
+		if err != nil {
+			continue
+		}
+		cn.send(&message{topic: ev.Topic, payload: payload, qos: qos})
+	}
+}
+
+// send sends a PUBLISH packet to the client
+func (cn *conn) send(msg *message) error {
+	cn.wlk.Lock()
+	defer cn.wlk.Unlock()
+
+	e := &encoder{}
+	e.string(msg.topic)
+	if msg.qos > 0 {
+		cn.lastID++
+		if cn.lastID == 0 {
+			cn.lastID = 1
+		}
+		e.uint16(cn.lastID)
+	}
+	e.properties(cn.version)
+	e.buf = append(e.buf, msg.payload...)
+
+	var flags byte
+	if msg.retain {
+		flags |= 0x01
+	}
+	flags |= msg.qos << 1
+	return cn.writeLocked(typePublish, flags, e.buf)
+}
+
+func (cn *conn) handleUnsubscribe(p *packet) error {
+	if p.flags != 0x02 {
+		return errProtocol
+	}
+	d := &decoder{buf: p.body}
+	id := d.uint16()
+	if cn.version >= version5 {
+		d.properties()
+	}
+
+	e := &encoder{}
+	e.uint16(id)
+	e.properties(cn.version)
+	for len(d.buf) > 0 {
+		filter := d.string()
+		if d.err != nil {
+			return d.err
+		}
+
+		cn.subLk.Lock()
+		sub, ok := cn.subs[filter]
+		delete(cn.subs, filter)
+		cn.subLk.Unlock()
+
+		if ok {
+			cn.b.hub.Unsubscribe(sub.ch)
+		}
+		if cn.version >= version5 {
+			if ok {
+				e.byte(0)
+			} else {
+				e.byte(reasonNoSubscription)
+			}
+		}
+	}
+	return cn.write(typeUnsuback, 0, e.buf)
+}
+
+func (cn *conn) cleanup() {
+	cn.c.Close()
+
+	cn.subLk.Lock()
+	subs := cn.subs
+	cn.subs = nil
+	cn.subLk.Unlock()
+
+	for _, sub := range subs {
+		cn.b.hub.Unsubscribe(sub.ch)
+	}
+
+	if cn.will != nil {
+		// the connection was not closed with DISCONNECT
+		cn.b.publish(cn.will)
+	}
+}
@@ -0,0 +1,22 @@
+// Package mqtt is an embedded MQTT 3.1.1 and 5 broker backed by an [emitter.Hub].
+//
+// Messages published by clients are emitted on the hub with the payload as single
+// []byte argument, and client subscriptions are listeners on the hub, so MQTT devices
+// and local code can exchange events directly:
+//
+//	b := mqtt.NewBroker(hub)
+//	l, _ := net.Listen("tcp", ":1883")
+//	go b.Serve(l)
+//	http.Handle("/mqtt", b) // MQTT over WebSocket
+//
+// Topic filters are mapped to hub patterns, + becoming a parameter named after its
+// position and # a final {n...} parameter: sensors/+/temp is subscribed to as
+// sensors/{1}/temp. Events emitted by local code are sent to clients as is if they
+// have a single []byte or string argument, and encoded as JSON otherwise.
+//
+// QoS 0 and 1 are supported, and subscriptions requesting QoS 2 are granted QoS 1. MQTT 5
+// clients are told the maximum QoS is 1, and QoS 2 messages of MQTT 3.1.1 clients are
+// acknowledged with PUBREC and PUBCOMP and published once with QoS 1.
+// Retained messages and last will messages are supported. Sessions are not persisted:
+// all subscriptions are removed when a client disconnects.
+package mqtt
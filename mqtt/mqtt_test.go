@@ -0,0 +1,401 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+	"github.com/KarpelesLab/emitter/internal/ws"
+)
+
+// testClient is a minimal MQTT client
+type testClient struct {
+	t       *testing.T
+	c       net.Conn
+	br      *bufio.Reader
+	version byte
+	lastID  uint16
+	connack *packet
+}
+
+func startBroker(t *testing.T) (*emitter.Hub, *Broker, string) {
+	h := emitter.New()
+	b := NewBroker(h)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	go b.Serve(l)
+	t.Cleanup(func() {
+		b.Close()
+		h.Close()
+	})
+	return h, b, l.Addr().String()
+}
+
+func dialTCP(t *testing.T, addr string) net.Conn {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func connect(t *testing.T, c net.Conn, version byte, id string, will *message) *testClient {
+	tc := &testClient{t: t, c: c, br: bufio.NewReader(c), version: version}
+
+	e := &encoder{}
+	e.string("MQTT")
+	e.byte(version)
+	flags := byte(0x02) // clean session
+	if will != nil {
+		flags |= 0x04 | will.qos<<3
+		if will.retain {
+			flags |= 0x20
+		}
+	}
+	e.byte(flags)
+	e.uint16(60)
+	e.properties(version)
+	e.string(id)
+	if will != nil {
+		e.properties(version)
+		e.string(will.topic)
+		e.binary(will.payload)
+	}
+	tc.write(typeConnect, 0, e.buf)
+
+	p := tc.read()
+	if p.typ != typeConnack || len(p.body) < 2 || p.body[1] != connackAccepted {
+		t.Fatalf("connection refused: %+v", p)
+	}
+	tc.connack = p
+	return tc
+}
+
+func (tc *testClient) write(typ, flags byte, body []byte) {
+	if err := writePacket(tc.c, typ, flags, body); err != nil {
+		tc.t.Fatalf("failed to write packet: %s", err)
+	}
+}
+
+func (tc *testClient) read() *packet {
+	tc.c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p, err := readPacket(tc.br, DefaultMaxPacketSize)
+	if err != nil {
+		tc.t.Fatalf("failed to read packet: %s", err)
+	}
+	return p
+}
+
+func (tc *testClient) publish(topic, payload string, qos byte, retain bool) {
+	e := &encoder{}
+	e.string(topic)
+	if qos > 0 {
+		tc.lastID++
+		e.uint16(tc.lastID)
+	}
+	e.properties(tc.version)
+	e.buf = append(e.buf, payload...)
+
+	flags := qos << 1
+	if retain {
+		flags |= 0x01
+	}
+	tc.write(typePublish, flags, e.buf)
+
+	if qos > 0 {
+		p := tc.read()
+		d := &decoder{buf: p.body}
+		if p.typ != typePuback || d.uint16() != tc.lastID {
+			tc.t.Fatalf("expected puback, got %+v", p)
+		}
+	}
+}
+
+func (tc *testClient) subscribe(filter string, qos byte) byte {
+	tc.lastID++
+	e := &encoder{}
+	e.uint16(tc.lastID)
+	e.properties(tc.version)
+	e.string(filter)
+	e.byte(qos)
+	tc.write(typeSubscribe, 0x02, e.buf)
+
+	p := tc.read()
+	d := &decoder{buf: p.body}
+	if p.typ != typeSuback || d.uint16() != tc.lastID {
+		tc.t.Fatalf("expected suback, got %+v", p)
+	}
+	if tc.version >= version5 {
+		d.properties()
+	}
+	return d.byte()
+}
+
+func (tc *testClient) expectPublish() *message {
+	p := tc.read()
+	if p.typ != typePublish {
+		tc.t.Fatalf("expected publish, got %+v", p)
+	}
+	msg := &message{qos: (p.flags >> 1) & 0x03, retain: p.flags&0x01 != 0}
+	d := &decoder{buf: p.body}
+	msg.topic = d.string()
+	if msg.qos > 0 {
+		id := d.uint16()
+		e := &encoder{}
+		e.uint16(id)
+		tc.write(typePuback, 0, e.buf)
+	}
+	if tc.version >= version5 {
+		d.properties()
+	}
+	msg.payload = d.rest()
+	return msg
+}
+
+func TestFilterToPattern(t *testing.T) {
+	tests := []struct {
+		filter, pattern string
+		ok              bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/+/c", "a/{1}/c", true},
+		{"+/+", "{0}/{1}", true},
+		{"a/#", "a/{1...}", true},
+		{"#", "{0...}", true},
+		{"a/#/c", "", false},
+		{"a/b+", "", false},
+		{"a/{x}", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		res, err := filterToPattern(test.filter)
+		if (err == nil) != test.ok || res != test.pattern {
+			t.Errorf("filterToPattern(%q) = %q, %v", test.filter, res, err)
+		}
+	}
+
+	if !matchFilter("a/#", "a") || !matchFilter("a/+/c", "a/b/c") || matchFilter("a/+", "a/b/c") || matchFilter("#", "$SYS/x") {
+		t.Errorf("matchFilter returned unexpected results")
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	h, _, addr := startBroker(t)
+
+	// client to hub
+	local := h.OnWithCap("sensors/kitchen/temp", 1)
+	pub := connect(t, dialTCP(t, addr), version311, "pub", nil)
+	pub.publish("sensors/kitchen/temp", "21.5", 1, false)
+
+	select {
+	case ev := <-local:
+		if b, ok := ev.Arg(0).([]byte); !ok || string(b) != "21.5" {
+			t.Errorf("unexpected argument %#v", ev.Arg(0))
+		}
+		if ev.Header[HeaderClientID] != "pub" || ev.Header[HeaderQoS] != "1" {
+			t.Errorf("unexpected headers %v", ev.Header)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event not received by local listener")
+	}
+
+	// hub to client, through a wildcard subscription
+	sub := connect(t, dialTCP(t, addr), version311, "sub", nil)
+	if granted := sub.subscribe("sensors/+/temp", 2); granted != 1 {
+		t.Errorf("expected QoS 1 to be granted, got %d", granted)
+	}
+	go h.EmitTimeout(time.Second, "sensors/garage/temp", map[string]int{"celsius": 12})
+	msg := sub.expectPublish()
+	if msg.topic != "sensors/garage/temp" || string(msg.payload) != `{"celsius":12}` || msg.qos != 1 {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	// client to client, QoS being the lowest of publish and subscription
+	pub.publish("sensors/kitchen/temp", "22", 0, false)
+	msg = sub.expectPublish()
+	if msg.topic != "sensors/kitchen/temp" || string(msg.payload) != "22" || msg.qos != 0 {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	// multi level wildcard also matches the parent level
+	all := connect(t, dialTCP(t, addr), version311, "all", nil)
+	all.subscribe("home/#", 0)
+	pub.publish("home", "root", 0, false)
+	if msg = all.expectPublish(); msg.topic != "home" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
+
+func TestQoS2(t *testing.T) {
+	h, _, addr := startBroker(t)
+	local := h.OnWithCap("orders", 2)
+
+	// MQTT 3.1.1 clients are acknowledged with the QoS 2 flow, and messages published once
+	tc := connect(t, dialTCP(t, addr), version311, "pub", nil)
+	e := &encoder{}
+	e.string("orders")
+	e.uint16(7)
+	e.buf = append(e.buf, "o1"...)
+	for _, flags := range []byte{0x04, 0x0c} { // retransmitted with DUP
+		tc.write(typePublish, flags, e.buf)
+		if p := tc.read(); p.typ != typePubrec || (&decoder{buf: p.body}).uint16() != 7 {
+			t.Fatalf("expected pubrec, got %+v", p)
+		}
+	}
+	tc.write(typePubrel, 0x02, []byte{0, 7})
+	if p := tc.read(); p.typ != typePubcomp || (&decoder{buf: p.body}).uint16() != 7 {
+		t.Fatalf("expected pubcomp, got %+v", p)
+	}
+	if len(local) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(local))
+	}
+	if ev := <-local; ev.Header[HeaderQoS] != "1" {
+		t.Errorf("unexpected headers %v", ev.Header)
+	}
+
+	// MQTT 5 clients are told the maximum QoS is 1
+	tc = connect(t, dialTCP(t, addr), version5, "pub5", nil)
+	d := &decoder{buf: tc.connack.body[2:]}
+	props := d.take(d.varint())
+	if len(props) < 2 || props[0] != 0x24 || props[1] != 1 {
+		t.Errorf("unexpected connack properties %v", props)
+	}
+	e = &encoder{}
+	e.string("orders")
+	e.uint16(1)
+	e.properties(version5)
+	tc.write(typePublish, 0x04, e.buf)
+	if p := tc.read(); p.typ != typeDisconnect || p.body[0] != reasonQoSNotSupported {
+		t.Errorf("expected disconnect, got %+v", p)
+	}
+}
+
+func TestRetained(t *testing.T) {
+	_, _, addr := startBroker(t)
+
+	pub := connect(t, dialTCP(t, addr), version311, "pub", nil)
+	pub.publish("status/door", "open", 1, true)
+	pub.publish("status/window", "closed", 1, true)
+
+	sub := connect(t, dialTCP(t, addr), version311, "sub", nil)
+	sub.subscribe("status/door", 1)
+	msg := sub.expectPublish()
+	if !msg.retain || msg.topic != "status/door" || string(msg.payload) != "open" {
+		t.Errorf("unexpected retained message %+v", msg)
+	}
+
+	// live messages are not flagged as retained
+	pub.publish("status/door", "closed", 0, true)
+	if msg = sub.expectPublish(); msg.retain || string(msg.payload) != "closed" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	// an empty payload clears the retained message
+	pub.publish("status/window", "", 0, true)
+	late := connect(t, dialTCP(t, addr), version311, "late", nil)
+	late.subscribe("status/+", 0)
+	if msg = late.expectPublish(); msg.topic != "status/door" || string(msg.payload) != "closed" {
+		t.Errorf("unexpected retained message %+v", msg)
+	}
+	late.c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := readPacket(late.br, DefaultMaxPacketSize); err == nil {
+		t.Errorf("cleared retained message was delivered")
+	}
+}
+
+func TestWill(t *testing.T) {
+	h, _, addr := startBroker(t)
+	ch := h.OnWithCap("clients/status", 2)
+
+	// closing the connection abruptly publishes the will
+	c := dialTCP(t, addr)
+	connect(t, c, version311, "dev1", &message{topic: "clients/status", payload: []byte("dev1 lost"), qos: 1})
+	c.Close()
+
+	select {
+	case ev := <-ch:
+		if b, _ := ev.Arg(0).([]byte); string(b) != "dev1 lost" {
+			t.Errorf("unexpected will %#v", ev.Arg(0))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("will was not published")
+	}
+
+	// a normal disconnection discards it
+	c = dialTCP(t, addr)
+	tc := connect(t, c, version311, "dev2", &message{topic: "clients/status", payload: []byte("dev2 lost")})
+	tc.write(typeDisconnect, 0, nil)
+	c.Close()
+
+	select {
+	case ev := <-ch:
+		t.Errorf("unexpected will %#v", ev.Arg(0))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAuth(t *testing.T) {
+	_, b, addr := startBroker(t)
+	b.Auth = func(clientID, username string, password []byte) bool {
+		return username == "admin"
+	}
+
+	tc := &testClient{t: t, c: dialTCP(t, addr), version: version311}
+	tc.br = bufio.NewReader(tc.c)
+	e := &encoder{}
+	e.string("MQTT")
+	e.byte(version311)
+	e.byte(0x82) // clean session, username
+	e.uint16(0)
+	e.string("dev")
+	e.string("guest")
+	tc.write(typeConnect, 0, e.buf)
+
+	if p := tc.read(); p.typ != typeConnack || p.body[1] != connackNotAuthorized {
+		t.Errorf("expected connection to be refused, got %+v", p)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	b := NewBroker(h)
+	defer b.Close()
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wc, err := ws.Dial(ctx, "ws://"+strings.TrimPrefix(srv.URL, "http://")+"/mqtt", "mqtt", nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	c := wc.NetConn()
+	defer c.Close()
+
+	// MQTT 5 over WebSocket, with an assigned client id
+	tc := connect(t, c, version5, "", nil)
+	tc.subscribe("chat/#", 1)
+
+	local := h.OnWithCap("chat/general", 1)
+	tc.publish("chat/general", "hello", 0, false)
+	if msg := tc.expectPublish(); msg.topic != "chat/general" || string(msg.payload) != "hello" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	select {
+	case ev := <-local:
+		if !strings.HasPrefix(ev.Header[HeaderClientID], "auto-") {
+			t.Errorf("unexpected client id %q", ev.Header[HeaderClientID])
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event not received by local listener")
+	}
+}
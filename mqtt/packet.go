@@ -0,0 +1,212 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Control packet types
+const (
+	typeConnect     = 1
+	typeConnack     = 2
+	typePublish     = 3
+	typePuback      = 4
+	typePubrec      = 5
+	typePubrel      = 6
+	typePubcomp     = 7
+	typeSubscribe   = 8
+	typeSuback      = 9
+	typeUnsubscribe = 10
+	typeUnsuback    = 11
+	typePingreq     = 12
+	typePingresp    = 13
+	typeDisconnect  = 14
+)
+
+// Protocol levels
+const (
+	version311 = 4
+	version5   = 5
+)
+
+// DefaultMaxPacketSize is the default maximum size of packets accepted from clients.
+const DefaultMaxPacketSize = 1 << 20
+
+var (
+	// ErrMalformed is returned when a packet cannot be decoded.
+	ErrMalformed = errors.New("mqtt: malformed packet")
+
+	// ErrPacketTooLarge is returned when a packet exceeds the maximum size.
+	ErrPacketTooLarge = errors.New("mqtt: packet too large")
+)
+
+// packet is a raw control packet
+type packet struct {
+	typ   byte // packet type, the high nibble of the first byte
+	flags byte // low nibble of the first byte
+	body  []byte
+}
+
+func readPacket(r *bufio.Reader, maxSize int) (*packet, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := readVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if ln > maxSize {
+		return nil, ErrPacketTooLarge
+	}
+	p := &packet{typ: b >> 4, flags: b & 0x0f, body: make([]byte, ln)}
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func writePacket(w io.Writer, typ, flags byte, body []byte) error {
+	buf := make([]byte, 0, len(body)+5)
+	buf = append(buf, typ<<4|flags)
+	buf = appendVarint(buf, len(body))
+	buf = append(buf, body...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readVarint(r io.ByteReader) (int, error) {
+	var res int
+	for n := 0; n < 4; n++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		res |= int(b&0x7f) << (7 * n)
+		if b&0x80 == 0 {
+			return res, nil
+		}
+	}
+	return 0, ErrMalformed
+}
+
+func appendVarint(buf []byte, v int) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if v == 0 {
+			return buf
+		}
+	}
+}
+
+// decoder reads fields from a packet body. Once an error happens, all following reads
+// return zero values and err is set.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.err = ErrMalformed
+		return nil
+	}
+	res := d.buf[:n]
+	d.buf = d.buf[n:]
+	return res
+}
+
+func (d *decoder) byte() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) binary() []byte {
+	return d.take(int(d.uint16()))
+}
+
+func (d *decoder) string() string {
+	return string(d.binary())
+}
+
+func (d *decoder) varint() int {
+	if d.err != nil {
+		return 0
+	}
+	r := &byteReader{d: d}
+	v, err := readVarint(r)
+	if err != nil {
+		d.err = ErrMalformed
+	}
+	return v
+}
+
+// properties skips MQTT 5 properties, which are not used by the broker
+func (d *decoder) properties() {
+	d.take(d.varint())
+}
+
+func (d *decoder) rest() []byte {
+	return d.take(len(d.buf))
+}
+
+type byteReader struct {
+	d *decoder
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	b := r.d.take(1)
+	if b == nil {
+		return 0, ErrMalformed
+	}
+	return b[0], nil
+}
+
+// encoder builds a packet body
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, v)
+}
+
+func (e *encoder) binary(b []byte) {
+	e.uint16(uint16(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.uint16(uint16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// properties writes an empty property list for MQTT 5
+func (e *encoder) properties(version byte) {
+	if version >= version5 {
+		e.byte(0)
+	}
+}
@@ -0,0 +1,59 @@
+package mqtt
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errInvalidTopic = errors.New("mqtt: invalid topic")
+
+// validTopic returns true if name can be published to. Besides MQTT wildcards, curly
+// braces are refused as they would be interpreted as hub patterns.
+func validTopic(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "+#{}\x00")
+}
+
+// filterToPattern converts an MQTT topic filter into a hub topic or pattern. The
+// single level wildcard + becomes a parameter named after its position, and the multi
+// level wildcard # becomes a final {n...} parameter, so that a/+/c/# becomes
+// a/{1}/c/{3...}. As # also matches the parent level, a/# matches a.
+func filterToPattern(filter string) (string, error) {
+	if filter == "" || strings.ContainsAny(filter, "{}\x00") {
+		return "", errInvalidTopic
+	}
+	levels := strings.Split(filter, "/")
+	for n, lv := range levels {
+		switch {
+		case lv == "+":
+			levels[n] = "{" + strconv.Itoa(n) + "}"
+		case lv == "#":
+			if n != len(levels)-1 {
+				return "", errInvalidTopic
+			}
+			levels[n] = "{" + strconv.Itoa(n) + "...}"
+		case strings.ContainsAny(lv, "+#"):
+			return "", errInvalidTopic
+		}
+	}
+	return strings.Join(levels, "/"), nil
+}
+
+// matchFilter returns true if topic matches the given MQTT topic filter
+func matchFilter(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		// wildcards do not match topics starting with $ at the first level
+		return false
+	}
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for n, lv := range fl {
+		if lv == "#" {
+			return true
+		}
+		if n >= len(tl) || (lv != "+" && lv != tl[n]) {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
@@ -0,0 +1,48 @@
+package emitter
+
+import "strings"
+
+// Namespace returns a scoped view of the hub, where all topic and trigger names are
+// transparently prefixed with the given name followed by a slash. The namespace shares
+// the storage of its parent: events emitted on invoice in the namespace billing are
+// received by listeners of billing/invoice on the parent, and conversely.
+//
+// Closing the namespace only closes its own topics and triggers, which makes it suitable
+// to hand over to libraries that should not be able to interfere with the rest of the
+// application. [Event.Topic] always contains the full topic name, including the prefix.
+//
+// The namespace initially has the same Cap and Bubble settings as its parent, and can be
+// configured independently. Errors are reported through the OnError of the root hub,
+// which also provides the Breaker and MaxInFlight settings.
+func (h *Hub) Namespace(name string) *Hub {
+	return &Hub{
+		Cap:    h.Cap,
+		Bubble: h.Bubble,
+		parent: h.root(),
+		prefix: h.name(name),
+	}
+}
+
+// root returns the hub holding the storage
+func (h *Hub) root() *Hub {
+	if h.parent != nil {
+		return h.parent
+	}
+	return h
+}
+
+// name returns the full name of the given topic or trigger in this hub
+func (h *Hub) name(n string) string {
+	if h.prefix == "" {
+		return n
+	}
+	return h.prefix + "/" + n
+}
+
+// relative returns the name of a topic relative to this hub's namespace
+func (h *Hub) relative(n string) string {
+	if h.prefix == "" {
+		return n
+	}
+	return strings.TrimPrefix(n, h.prefix+"/")
+}
@@ -0,0 +1,120 @@
+package emitter_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func TestNamespace(t *testing.T) {
+	h := emitter.New()
+	h.Cap = 1
+	ns := h.Namespace("billing")
+
+	fromParent := h.On("billing/invoice")
+	fromNs := ns.On("invoice")
+
+	if err := ns.Emit(context.Background(), "invoice", 1); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	for _, ch := range []<-chan *emitter.Event{fromParent, fromNs} {
+		ev := <-ch
+		if ev.Topic != "billing/invoice" {
+			t.Errorf("unexpected topic %s", ev.Topic)
+		}
+	}
+
+	if err := h.Emit(context.Background(), "billing/invoice", 2); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	<-fromParent
+	<-fromNs
+
+	if err := ns.Emit(context.Background(), "other"); err != emitter.ErrNoSuchTopic {
+		t.Errorf("expected ErrNoSuchTopic, got %v", err)
+	}
+
+	// nested namespaces
+	sub := ns.Namespace("eu")
+	nested := h.On("billing/eu/invoice")
+	if err := sub.Emit(context.Background(), "invoice"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	<-nested
+}
+
+func TestNamespaceClose(t *testing.T) {
+	h := emitter.New()
+	ns := h.Namespace("lib")
+
+	inside := ns.On("topic")
+	trig := ns.Trigger("trig").Listen()
+	outside := h.On("topic")
+	outsideTrig := h.Trigger("trig").Listen()
+	defer outsideTrig.Release()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range inside {
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range trig.C {
+		}
+	}()
+
+	if err := ns.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	wg.Wait()
+
+	// the parent's topics must still work
+	go func() {
+		<-outside
+	}()
+	if err := h.EmitTimeout(time.Second, "topic", "data"); err != nil {
+		t.Errorf("Emit on parent failed: %v", err)
+	}
+	h.Push("trig")
+	select {
+	case <-outsideTrig.C:
+	case <-time.After(time.Second):
+		t.Error("parent trigger closed by namespace")
+	}
+}
+
+func TestNamespacePatternAndBubble(t *testing.T) {
+	h := emitter.New()
+	h.Cap = 1
+	ns := h.Namespace("app")
+
+	ch, err := ns.OnPattern("user/{id}")
+	if err != nil {
+		t.Fatalf("OnPattern failed: %v", err)
+	}
+	if err := h.Emit(context.Background(), "app/user/42"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	if ev := <-ch; ev.Param("id") != "42" {
+		t.Errorf("unexpected param %q", ev.Param("id"))
+	}
+
+	// bubbling must not leave the namespace
+	root := h.On("app")
+	user := ns.On("user")
+	if err := ns.EmitBubble(context.Background(), "user/1/profile"); err != nil {
+		t.Fatalf("EmitBubble failed: %v", err)
+	}
+	<-user
+	select {
+	case <-root:
+		t.Error("event propagated outside of the namespace")
+	default:
+	}
+}
@@ -0,0 +1,79 @@
+package emitter
+
+import "sync"
+
+// Ordering defines the guarantees on the order in which the listeners of a topic receive
+// concurrently emitted events, see [Hub.SetOrdering].
+type Ordering int
+
+const (
+	// Unordered is the default: concurrent emits on a topic run in parallel, and listeners
+	// may receive their events in different orders.
+	Unordered Ordering = iota
+
+	// Strict serializes emits on the topic, all listeners receive events in the same order.
+	Strict
+
+	// Keyed serializes emits of events having the same [Event.Key], all listeners receive
+	// the events of a given key in the same order. Events with different keys are emitted
+	// in parallel.
+	Keyed
+)
+
+// SetOrdering sets the ordering of events emitted on topic. With [Strict] or [Keyed]
+// ordering, an emit waits for the previous one to complete, including when it is waiting
+// for slow listeners. A listener must therefore not emit on its own topic synchronously.
+//
+// The topic is created if needed.
+func (h *Hub) SetOrdering(topic string, o Ordering) {
+	t := h.getTopic(h.name(topic), true)
+	t.listenersLk.Lock()
+	defer t.listenersLk.Unlock()
+	t.ordering = o
+}
+
+// keyLock serializes the emits of a given key
+type keyLock struct {
+	lk   sync.Mutex
+	refs int
+}
+
+// lockOrder waits for the emits that must happen before ev, and returns a function to call
+// once ev has been emitted
+func (t *topic) lockOrder(ev *Event) func() {
+	t.listenersLk.RLock()
+	o := t.ordering
+	t.listenersLk.RUnlock()
+
+	switch o {
+	case Strict:
+		t.orderLk.Lock()
+		return t.orderLk.Unlock
+	case Keyed:
+		t.keysLk.Lock()
+		if t.keys == nil {
+			t.keys = make(map[string]*keyLock)
+		}
+		kl, ok := t.keys[ev.Key]
+		if !ok {
+			kl = &keyLock{}
+			t.keys[ev.Key] = kl
+		}
+		kl.refs += 1
+		t.keysLk.Unlock()
+
+		kl.lk.Lock()
+		return func() {
+			kl.lk.Unlock()
+
+			t.keysLk.Lock()
+			defer t.keysLk.Unlock()
+			kl.refs -= 1
+			if kl.refs == 0 {
+				delete(t.keys, ev.Key)
+			}
+		}
+	default:
+		return func() {}
+	}
+}
@@ -0,0 +1,126 @@
+package emitter_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// collect starts n listeners on topic and returns a function waiting until each of them
+// received cnt events and returning what they received
+func collect(h *emitter.Hub, topic string, n, cnt int) func() [][]*emitter.Event {
+	res := make([][]*emitter.Event, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		ch := h.On(topic)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ev := range ch {
+				res[i] = append(res[i], ev)
+				if len(res[i]) == cnt {
+					return
+				}
+			}
+		}()
+	}
+	return func() [][]*emitter.Event {
+		wg.Wait()
+		return res
+	}
+}
+
+// emitConcurrently emits cnt events on topic from each of the given number of goroutines
+func emitConcurrently(t *testing.T, h *emitter.Hub, topic string, emitters, cnt int, keys []string) {
+	var wg sync.WaitGroup
+	for e := range emitters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range cnt {
+				ev := &emitter.Event{
+					Context: context.Background(),
+					Args:    []any{fmt.Sprintf("%d/%d", e, n)},
+				}
+				if keys != nil {
+					ev.Key = keys[(e+n)%len(keys)]
+				}
+				if err := h.EmitEvent(context.Background(), topic, ev); err != nil {
+					t.Errorf("emit failed: %s", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestOrderingStrict(t *testing.T) {
+	const listeners, emitters, cnt = 8, 8, 200
+
+	h := emitter.New()
+	defer h.Close()
+	h.SetOrdering("stream", emitter.Strict)
+
+	wait := collect(h, "stream", listeners, emitters*cnt)
+	emitConcurrently(t, h, "stream", emitters, cnt, nil)
+	res := wait()
+
+	for i := 1; i < listeners; i += 1 {
+		if !slices.Equal(res[0], res[i]) {
+			t.Fatalf("listeners 0 and %d received events in different orders", i)
+		}
+	}
+}
+
+func TestOrderingKeyed(t *testing.T) {
+	const listeners, emitters, cnt = 8, 8, 200
+	keys := []string{"a", "b", "c", "d", "e"}
+
+	h := emitter.New()
+	defer h.Close()
+	h.SetOrdering("stream", emitter.Keyed)
+
+	wait := collect(h, "stream", listeners, emitters*cnt)
+	emitConcurrently(t, h, "stream", emitters, cnt, keys)
+	res := wait()
+
+	// compare the sequences of each key
+	byKey := func(evs []*emitter.Event, key string) []*emitter.Event {
+		return slices.DeleteFunc(slices.Clone(evs), func(ev *emitter.Event) bool { return ev.Key != key })
+	}
+	for _, key := range keys {
+		ref := byKey(res[0], key)
+		if len(ref) == 0 {
+			t.Fatalf("no event for key %s", key)
+		}
+		for i := 1; i < listeners; i += 1 {
+			if !slices.Equal(ref, byKey(res[i], key)) {
+				t.Fatalf("listeners 0 and %d received events of key %s in different orders", i, key)
+			}
+		}
+	}
+}
+
+func TestOrderingPerEmitter(t *testing.T) {
+	// regardless of ordering, events of a single emitter are received in order
+	h := emitter.New()
+	defer h.Close()
+
+	wait := collect(h, "stream", 2, 100)
+	for n := range 100 {
+		h.Emit(context.Background(), "stream", strconv.Itoa(n))
+	}
+	for _, evs := range wait() {
+		for n, ev := range evs {
+			if ev.Arg(0) != strconv.Itoa(n) {
+				t.Fatalf("unexpected event %v at %d", ev.Arg(0), n)
+			}
+		}
+	}
+}
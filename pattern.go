@@ -0,0 +1,254 @@
+package emitter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+type segmentKind int
+
+const (
+	segLiteral segmentKind = iota
+	segParam               // {name}, matches exactly one segment
+	segRest                // {name...}, matches all remaining segments, possibly none
+)
+
+type patternSegment struct {
+	kind  segmentKind
+	value string // literal value or parameter name
+}
+
+// pattern is a parsed topic template such as tenant/{tenant}/order/{id}/paid
+type pattern struct {
+	str  string
+	segs []patternSegment
+}
+
+func parsePattern(s string) (*pattern, error) {
+	parts := strings.Split(s, "/")
+	res := &pattern{
+		str:  s,
+		segs: make([]patternSegment, 0, len(parts)),
+	}
+	names := make(map[string]bool)
+
+	for n, part := range parts {
+		if !strings.HasPrefix(part, "{") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("%w %q: invalid segment %q", ErrInvalidPattern, s, part)
+			}
+			res.segs = append(res.segs, patternSegment{kind: segLiteral, value: part})
+			continue
+		}
+		if !strings.HasSuffix(part, "}") {
+			return nil, fmt.Errorf("%w %q: invalid segment %q", ErrInvalidPattern, s, part)
+		}
+		name := part[1 : len(part)-1]
+		kind := segParam
+		if rest, ok := strings.CutSuffix(name, "..."); ok {
+			if n != len(parts)-1 {
+				return nil, fmt.Errorf("%w %q: %s must be the last segment", ErrInvalidPattern, s, part)
+			}
+			name = rest
+			kind = segRest
+		}
+		if name == "" || strings.ContainsAny(name, "{}") {
+			return nil, fmt.Errorf("%w %q: invalid parameter name in %q", ErrInvalidPattern, s, part)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("%w %q: duplicate parameter %q", ErrInvalidPattern, s, name)
+		}
+		names[name] = true
+		res.segs = append(res.segs, patternSegment{kind: kind, value: name})
+	}
+	return res, nil
+}
+
+// IsPattern returns true if s contains parameters and is a topic pattern rather than a
+// topic name, see [Hub.OnPattern].
+func IsPattern(s string) bool {
+	return strings.Contains(s, "{")
+}
+
+// match returns true if the topic matches this pattern
+func (p *pattern) match(topic string) bool {
+	_, ok := p.lookup(topic, "")
+	return ok
+}
+
+// lookup checks that topic matches this pattern, and returns the value of the given parameter
+func (p *pattern) lookup(topic, name string) (string, bool) {
+	parts := strings.Split(topic, "/")
+	var res string
+	for n, seg := range p.segs {
+		if seg.kind == segRest {
+			if seg.value == name {
+				res = strings.Join(parts[n:], "/")
+			}
+			return res, true
+		}
+		if n >= len(parts) {
+			return "", false
+		}
+		switch seg.kind {
+		case segLiteral:
+			if parts[n] != seg.value {
+				return "", false
+			}
+		case segParam:
+			if seg.value == name {
+				res = parts[n]
+			}
+		}
+	}
+	return res, len(parts) == len(p.segs)
+}
+
+// shape returns the pattern with parameter names removed. Two patterns with the same
+// shape match exactly the same topics.
+func (p *pattern) shape() string {
+	parts := make([]string, len(p.segs))
+	for n, seg := range p.segs {
+		switch seg.kind {
+		case segLiteral:
+			parts[n] = seg.value
+		case segParam:
+			parts[n] = "{}"
+		case segRest:
+			parts[n] = "{...}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (p *pattern) has(name string) bool {
+	for _, seg := range p.segs {
+		if seg.kind != segLiteral && seg.value == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rank returns how specific segment n is, higher being more specific
+func (p *pattern) rank(n int) int {
+	if n >= len(p.segs) {
+		// no segment at all is more specific than a {rest...} that can match nothing
+		return 4
+	}
+	switch p.segs[n].kind {
+	case segLiteral:
+		return 3
+	case segParam:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// compareSpecificity returns a negative value if a is more specific than b, a positive value
+// if b is more specific, and zero if both are equally specific. Segments are compared from left
+// to right, a literal being more specific than a parameter.
+func compareSpecificity(a, b *pattern) int {
+	for n := 0; n < max(len(a.segs), len(b.segs)); n += 1 {
+		if d := b.rank(n) - a.rank(n); d != 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Param returns the value of the named parameter extracted from the event's topic by the
+// pattern it was received through (see [Hub.OnPattern]), or an empty string if no such
+// parameter exists. If the listener registered several patterns matching the topic, the
+// most specific one is used.
+func (ev *Event) Param(name string) string {
+	if ev.pattern == nil || !ev.pattern.has(name) {
+		return ""
+	}
+	v, _ := ev.pattern.lookup(ev.current(), name)
+	return v
+}
+
+type patternListener struct {
+	pat *pattern
+	l   *listener
+	ch  <-chan *Event
+}
+
+// OnPattern returns a channel that will receive events from all the topics matching the given
+// pattern, including topics that do not exist yet. A pattern is a topic name where some of the
+// slash-separated segments are parameters, such as:
+//
+//	tenant/{tenant}/order/{id}/paid
+//
+// Each parameter matches exactly one segment, except a final parameter written {name...} which
+// matches all the remaining segments. Values of parameters can be obtained with [Event.Param]
+// on the events received, which are copies bound to the pattern.
+//
+// The channel can be detached from all the matching topics with [Hub.Unsubscribe].
+func (h *Hub) OnPattern(pat string) (<-chan *Event, error) {
+	p, err := parsePattern(h.name(pat))
+	if err != nil {
+		return nil, err
+	}
+	l := newListener(h.Cap)
+	ch := l.ch
+	h.addPattern(p, l, ch)
+	return ch, nil
+}
+
+// addPattern registers l to receive the events of the topics matching p. Patterns are
+// matched during emit, so registering does not wait for emits in progress.
+func (h *Hub) addPattern(p *pattern, l *listener, ch <-chan *Event) {
+	if h.parent != nil {
+		h.parent.addPattern(p, l, ch)
+		return
+	}
+
+	h.topicsLk.Lock()
+	defer h.topicsLk.Unlock()
+
+	// the registration holds a reference on l until it is unsubscribed
+	atomic.AddInt32(&l.refs, 1)
+	h.patterns = append(h.patterns, &patternListener{pat: p, l: l, ch: ch})
+}
+
+// listenPatterns returns the registrations of the patterns matching topic, most specific
+// first. A reference is held on their listeners so they are not closed before the event
+// is delivered, see releasePatterns.
+func (h *Hub) listenPatterns(topic string) []*patternListener {
+	h.topicsLk.RLock()
+	defer h.topicsLk.RUnlock()
+
+	var res []*patternListener
+	for _, pl := range h.patterns {
+		if pl.pat.match(topic) {
+			atomic.AddInt32(&pl.l.refs, 1)
+			res = append(res, pl)
+		}
+	}
+	slices.SortStableFunc(res, func(a, b *patternListener) int {
+		return compareSpecificity(a.pat, b.pat)
+	})
+	return res
+}
+
+// releasePatterns releases the references taken by listenPatterns
+func releasePatterns(pats []*patternListener) {
+	for _, pl := range pats {
+		pl.l.release()
+	}
+}
+
+// matchPattern returns true if any registered pattern matches topic. topicsLk must be held.
+func (h *Hub) matchPattern(topic string) bool {
+	for _, pl := range h.patterns {
+		if pl.pat.match(topic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+package emitter_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func TestOnPattern(t *testing.T) {
+	h := emitter.New()
+	h.Cap = 10
+
+	// existing topics matching the pattern are attached too
+	existing := h.On("tenant/acme/order/1/paid")
+	go func() {
+		for range existing {
+		}
+	}()
+
+	ch, err := h.OnPattern("tenant/{tenant}/order/{id}/paid")
+	if err != nil {
+		t.Fatalf("OnPattern failed: %v", err)
+	}
+
+	topics := []string{
+		"tenant/acme/order/1/paid",
+		"tenant/acme/order/2/created", // no match
+		"tenant/other/order/42/paid",
+		"tenant/other/order/42/paid/extra", // no match
+	}
+	for _, topic := range topics {
+		err := h.Emit(context.Background(), topic, "data")
+		if err != nil && err != emitter.ErrNoSuchTopic {
+			t.Fatalf("Emit failed: %v", err)
+		}
+	}
+
+	expect := [][2]string{{"acme", "1"}, {"other", "42"}}
+	for _, e := range expect {
+		ev := <-ch
+		if ev.Param("tenant") != e[0] || ev.Param("id") != e[1] {
+			t.Errorf("unexpected params tenant=%q id=%q on %s", ev.Param("tenant"), ev.Param("id"), ev.Topic)
+		}
+		if ev.Param("missing") != "" {
+			t.Errorf("unexpected value for missing param")
+		}
+	}
+
+	select {
+	case ev := <-ch:
+		t.Errorf("unexpected event on %s", ev.Topic)
+	default:
+	}
+
+	h.Unsubscribe(ch)
+	for range ch {
+	}
+	if err := h.Emit(context.Background(), "tenant/x/order/1/paid", "data"); err != nil && err != emitter.ErrNoSuchTopic {
+		t.Errorf("Emit after Unsubscribe failed: %v", err)
+	}
+}
+
+func TestOnPatternRest(t *testing.T) {
+	h := emitter.New()
+	h.Cap = 10
+
+	ch, err := h.OnPattern("files/{path...}")
+	if err != nil {
+		t.Fatalf("OnPattern failed: %v", err)
+	}
+
+	for _, topic := range []string{"files", "files/a", "files/a/b/c"} {
+		if err := h.Emit(context.Background(), topic); err != nil {
+			t.Fatalf("Emit on %s failed: %v", topic, err)
+		}
+	}
+	for _, expect := range []string{"", "a", "a/b/c"} {
+		ev := <-ch
+		if ev.Param("path") != expect {
+			t.Errorf("unexpected path %q on %s, expected %q", ev.Param("path"), ev.Topic, expect)
+		}
+	}
+}
+
+func TestOnPatternErrors(t *testing.T) {
+	h := emitter.New()
+
+	for _, pat := range []string{"a/{", "a/{}/b", "a/{x}/{x}", "a/{rest...}/b", "a/b{c}"} {
+		if _, err := h.OnPattern(pat); !errors.Is(err, emitter.ErrInvalidPattern) {
+			t.Errorf("expected ErrInvalidPattern for %q, got %v", pat, err)
+		}
+	}
+
+	if _, err := h.OnPattern("tenant/{tenant}/order/{id}"); err != nil {
+		t.Fatalf("OnPattern failed: %v", err)
+	}
+	// same pattern can be subscribed to several times
+	if _, err := h.OnPattern("tenant/{tenant}/order/{id}"); err != nil {
+		t.Errorf("OnPattern with same pattern failed: %v", err)
+	}
+	// independent subscribers may name parameters differently
+	if _, err := h.OnPattern("tenant/{t}/order/{id}"); err != nil {
+		t.Errorf("OnPattern with different names failed: %v", err)
+	}
+}
+
+func TestOnPatternTopics(t *testing.T) {
+	h := emitter.New()
+	h.Cap = 100
+
+	ch, _ := h.OnPattern("user/{id}")
+	for n := range 100 {
+		if err := h.Emit(context.Background(), fmt.Sprintf("user/%d", n)); err != nil {
+			t.Fatalf("Emit failed: %v", err)
+		}
+	}
+	if len(ch) != 100 {
+		t.Errorf("expected 100 events, got %d", len(ch))
+	}
+
+	// no topic was created for the matching names
+	h.Unsubscribe(ch)
+	if err := h.Emit(context.Background(), "user/1"); err != emitter.ErrNoSuchTopic {
+		t.Errorf("expected ErrNoSuchTopic after Unsubscribe, got %v", err)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	h := emitter.New()
+	r := h.NewRouter()
+	defer r.Close()
+
+	var lk sync.Mutex
+	got := make(map[string]string)
+	var wg sync.WaitGroup
+
+	handler := func(name string) func(*emitter.Event) {
+		return func(ev *emitter.Event) {
+			lk.Lock()
+			defer lk.Unlock()
+			got[ev.Topic] = name
+			wg.Done()
+		}
+	}
+
+	routes := map[string]string{
+		"tenant/{tenant}/order/{id}":   "generic",
+		"tenant/{tenant}/order/latest": "latest",
+		"tenant/acme/order/{id}":       "acme",
+		"tenant/{tenant}/{rest...}":    "fallback",
+	}
+	for pat, name := range routes {
+		if err := r.Handle(pat, handler(name)); err != nil {
+			t.Fatalf("Handle(%q) failed: %v", pat, err)
+		}
+	}
+	if err := r.Handle("tenant/{x}/order/{y}", handler("dup")); !errors.Is(err, emitter.ErrPatternCollision) {
+		t.Errorf("expected ErrPatternCollision, got %v", err)
+	}
+
+	expect := map[string]string{
+		"tenant/other/order/1":      "generic",
+		"tenant/other/order/latest": "latest",
+		"tenant/acme/order/latest":  "acme",
+		"tenant/acme/order/1":       "acme",
+		"tenant/other/invoice/1":    "fallback",
+	}
+	wg.Add(len(expect))
+	for topic := range expect {
+		if err := h.EmitTimeout(time.Second, topic); err != nil {
+			t.Fatalf("Emit on %s failed: %v", topic, err)
+		}
+	}
+	wg.Wait()
+
+	lk.Lock()
+	defer lk.Unlock()
+	for topic, name := range expect {
+		if got[topic] != name {
+			t.Errorf("topic %s dispatched to %q, expected %q", topic, got[topic], name)
+		}
+	}
+}
+
+func TestRouterHandleDuringEmit(t *testing.T) {
+	h := emitter.New()
+	r := h.NewRouter()
+	defer r.Close()
+
+	release := make(chan struct{})
+	if err := r.Handle("a/{x}", func(*emitter.Event) { <-release }); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+
+	// the first event blocks the handler, the second one waits to be received
+	emitted := make(chan error, 2)
+	for range 2 {
+		go func() { emitted <- h.EmitTimeout(5*time.Second, "a/1") }()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	handled := make(chan error, 1)
+	go func() { handled <- r.Handle("{y}/1", func(*emitter.Event) {}) }()
+	select {
+	case err := <-handled:
+		if err != nil {
+			t.Errorf("Handle failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Handle blocked by pending emits")
+	}
+
+	close(release)
+	for range 2 {
+		if err := <-emitted; err != nil {
+			t.Errorf("Emit failed: %v", err)
+		}
+	}
+}
+
+func TestOnPatternParamOverlap(t *testing.T) {
+	h := emitter.New()
+	h.Cap = 1
+
+	first, _ := h.OnPattern("{x}/b/c")
+	second, _ := h.OnPattern("a/{x}/c")
+	direct := h.On("a/b/c")
+	if err := h.Emit(context.Background(), "a/b/c"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+
+	// each listener gets the parameters of its own pattern
+	if x := (<-first).Param("x"); x != "a" {
+		t.Errorf("unexpected x=%q through {x}/b/c", x)
+	}
+	if x := (<-second).Param("x"); x != "b" {
+		t.Errorf("unexpected x=%q through a/{x}/c", x)
+	}
+	if x := (<-direct).Param("x"); x != "" {
+		t.Errorf("unexpected x=%q without pattern", x)
+	}
+}
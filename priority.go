@@ -0,0 +1,225 @@
+package emitter
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Default values of [PriorityOptions].
+const (
+	DefaultPriorityCap     = 64
+	DefaultStarvationLimit = 16
+)
+
+// PriorityOptions configures a listener created with [Hub.OnPriority].
+type PriorityOptions struct {
+	// Cap is the number of events the mailbox can hold, and defaults to
+	// [DefaultPriorityCap]. Once full, emitters wait for room as with a channel.
+	Cap int
+
+	// StarvationLimit is the number of consecutive events that can be received ahead of
+	// an older event of lower priority. Once reached, the oldest event is received next.
+	// It defaults to [DefaultStarvationLimit], and a negative value disables starvation
+	// protection.
+	StarvationLimit int
+}
+
+// EmitPriority emits an event with the given priority. Listeners created with
+// [Hub.OnPriority] receive events of higher priority first, other listeners receive
+// events in order.
+func (h *Hub) EmitPriority(ctx context.Context, topic string, prio int, args ...any) error {
+	ev := &Event{
+		Context:  ctx,
+		Topic:    h.name(topic),
+		Args:     args,
+		Priority: prio,
+	}
+
+	return h.emitEvent(ctx, ev, h.Bubble)
+}
+
+// OnPriority returns a channel receiving the events of topic by decreasing
+// [Event.Priority], then in emit order. Events are kept in a mailbox until received, so
+// an event of high priority is received before events of lower priority emitted earlier.
+//
+// The channel can be used with [Hub.Off] and [Hub.Unsubscribe]. Events still in the
+// mailbox when the listener is removed are discarded.
+func (h *Hub) OnPriority(topic string, opts PriorityOptions) <-chan *Event {
+	if opts.Cap <= 0 {
+		opts.Cap = DefaultPriorityCap
+	}
+	if opts.StarvationLimit == 0 {
+		opts.StarvationLimit = DefaultStarvationLimit
+	}
+
+	l := newListener(0)
+	m := &mailbox{
+		opts:   opts,
+		out:    make(chan *Event),
+		done:   l.ch, // closed once the listener is removed
+		notify: make(chan struct{}, 1),
+		room:   make(chan struct{}, 1),
+	}
+	l.push = m.push
+	l.stats = m.stats
+
+	h.getTopic(h.name(topic), true).appendListener(l, m.out)
+	go m.pump()
+	return m.out
+}
+
+// mailboxItem is an event waiting in a mailbox
+type mailboxItem struct {
+	ev    *Event
+	seq   uint64
+	index int
+}
+
+// mailboxHeap orders items by decreasing priority, then by sequence
+type mailboxHeap []*mailboxItem
+
+func (q mailboxHeap) Len() int { return len(q) }
+
+func (q mailboxHeap) Less(i, j int) bool {
+	if q[i].ev.Priority != q[j].ev.Priority {
+		return q[i].ev.Priority > q[j].ev.Priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q mailboxHeap) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *mailboxHeap) Push(x any) {
+	item := x.(*mailboxItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *mailboxHeap) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return item
+}
+
+type mailbox struct {
+	opts    PriorityOptions
+	out     chan *Event
+	done    <-chan *Event
+	notify  chan struct{} // signals the pump that an event was added
+	room    chan struct{} // signals emitters that an event was removed
+	lk      sync.Mutex
+	items   mailboxHeap
+	seq     uint64
+	skipped int // consecutive events received ahead of the oldest one
+}
+
+// push adds ev to the mailbox, waiting for room if it is full
+func (m *mailbox) push(ctx context.Context, ev *Event) error {
+	for {
+		m.lk.Lock()
+		if len(m.items) < m.opts.Cap {
+			m.seq += 1
+			heap.Push(&m.items, &mailboxItem{ev: ev, seq: m.seq})
+			m.lk.Unlock()
+			signal(m.notify)
+			return nil
+		}
+		m.lk.Unlock()
+
+		select {
+		case <-m.room:
+		case <-m.done:
+			// listener removed, the event is discarded
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// next returns the item to be received next, or nil if the mailbox is empty
+func (m *mailbox) next() *mailboxItem {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if len(m.items) == 0 {
+		return nil
+	}
+	if m.opts.StarvationLimit > 0 && m.skipped >= m.opts.StarvationLimit {
+		oldest := m.items[0]
+		for _, item := range m.items {
+			if item.seq < oldest.seq {
+				oldest = item
+			}
+		}
+		return oldest
+	}
+	return m.items[0]
+}
+
+// remove removes item once it has been received
+func (m *mailbox) remove(item *mailboxItem) {
+	m.lk.Lock()
+	oldest := true
+	for _, it := range m.items {
+		if it.seq < item.seq {
+			oldest = false
+			break
+		}
+	}
+	if oldest {
+		m.skipped = 0
+	} else {
+		m.skipped += 1
+	}
+	heap.Remove(&m.items, item.index)
+	m.lk.Unlock()
+
+	signal(m.room)
+}
+
+func (m *mailbox) pump() {
+	defer close(m.out)
+
+	for {
+		item := m.next()
+		if item == nil {
+			select {
+			case <-m.notify:
+				continue
+			case <-m.done:
+				return
+			}
+		}
+
+		select {
+		case m.out <- item.ev:
+			m.remove(item)
+		case <-m.notify:
+			// an event of higher priority may have been added
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *mailbox) stats(st *ListenerStats) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	st.Depth = len(m.items)
+}
+
+// signal wakes a goroutine waiting on ch, if any
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
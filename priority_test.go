@@ -0,0 +1,124 @@
+package emitter_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// receive reads n events from ch and returns their first argument
+func receive(t *testing.T, ch <-chan *emitter.Event, n int) []any {
+	t.Helper()
+	var res []any
+	for range n {
+		select {
+		case ev := <-ch:
+			res = append(res, ev.Arg(0))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event not received")
+		}
+	}
+	return res
+}
+
+func TestPriority(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	ctx := context.Background()
+
+	ch := h.OnPriority("jobs", emitter.PriorityOptions{})
+	for _, name := range []string{"bulk1", "bulk2", "bulk3"} {
+		h.Emit(ctx, "jobs", name)
+	}
+	h.EmitPriority(ctx, "jobs", 5, "auth-revoked")
+	h.EmitPriority(ctx, "jobs", 10, "shutdown")
+	time.Sleep(10 * time.Millisecond)
+
+	res := receive(t, ch, 5)
+	expected := []any{"shutdown", "auth-revoked", "bulk1", "bulk2", "bulk3"}
+	if !slices.Equal(res, expected) {
+		t.Errorf("unexpected order %v", res)
+	}
+
+	h.Unsubscribe(ch)
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("unexpected event after unsubscribe")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("channel was not closed")
+	}
+}
+
+func TestPriorityStarvation(t *testing.T) {
+	for _, tc := range []struct {
+		limit    int
+		expected []any
+	}{
+		{2, []any{"h1", "h2", "low", "h3", "h4", "h5"}},
+		{-1, []any{"h1", "h2", "h3", "h4", "h5", "low"}},
+	} {
+		h := emitter.New()
+		ctx := context.Background()
+
+		ch := h.OnPriority("jobs", emitter.PriorityOptions{StarvationLimit: tc.limit})
+		h.Emit(ctx, "jobs", "low")
+		for _, name := range []string{"h1", "h2", "h3", "h4", "h5"} {
+			h.EmitPriority(ctx, "jobs", 1, name)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		if res := receive(t, ch, 6); !slices.Equal(res, tc.expected) {
+			t.Errorf("limit %d: unexpected order %v", tc.limit, res)
+		}
+		h.Close()
+	}
+}
+
+func TestPriorityFull(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	ch := h.OnPriority("jobs", emitter.PriorityOptions{Cap: 1})
+	if err := h.EmitTimeout(time.Second, "jobs", 1); err != nil {
+		t.Fatalf("emit failed: %s", err)
+	}
+	if err := h.EmitTimeout(20*time.Millisecond, "jobs", 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected full mailbox to time out, got %v", err)
+	}
+	if res := receive(t, ch, 1); res[0] != 1 {
+		t.Errorf("unexpected event %v", res[0])
+	}
+}
+
+func TestPriorityFullMailbox(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	h.OnError = func(error) {}
+
+	h.OnPriority("jobs", emitter.PriorityOptions{Cap: 1}) // never read
+	ok := h.OnPriority("jobs", emitter.PriorityOptions{Cap: 100})
+
+	// a full mailbox does not prevent the others from receiving the event
+	failed := 0
+	for n := range 10 {
+		err := h.EmitTimeout(10*time.Millisecond, "jobs", n)
+		if err != nil {
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			failed += 1
+		}
+	}
+	if failed < 5 {
+		t.Errorf("expected the full mailbox to fail, got %d failures", failed)
+	}
+	if res := receive(t, ok, 10); !slices.Equal(res, []any{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("unexpected events %v", res)
+	}
+}
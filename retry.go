@@ -0,0 +1,229 @@
+package emitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetryMax is the default maximum number of attempts of a [RetryPolicy].
+	DefaultRetryMax = 5
+
+	// DefaultRetryQueue is the default number of events a [RetryHandler] can queue.
+	DefaultRetryQueue = 1000
+)
+
+// ErrRetryQueueFull is returned by emit and the event dead-lettered when the queue of a
+// [RetryHandler] is full.
+var ErrRetryQueueFull = errors.New("retry handler queue is full")
+
+// RetryPolicy configures how handlers registered with [Hub.HandleWithRetry] are retried.
+type RetryPolicy struct {
+	// Max is the maximum number of attempts, including the first one, [DefaultRetryMax]
+	// by default. If negative, the handler is retried until it succeeds or the deadline
+	// of the event's context is reached.
+	Max int
+
+	// Backoff returns the delay before each retry, [DefaultBackoff] by default.
+	Backoff Backoff
+
+	// Queue is the maximum number of events waiting to be handled, [DefaultRetryQueue]
+	// by default.
+	Queue int
+}
+
+// Retry returns a [RetryPolicy] making at most max attempts (see [RetryPolicy.Max]), waiting between attempts
+// as returned by backoff, for example [ExponentialBackoff] wrapped with [Jitter].
+func Retry(max int, backoff Backoff) RetryPolicy {
+	return RetryPolicy{Max: max, Backoff: backoff}
+}
+
+// RetryHandler calls a function for each event of a topic, retrying on error. See
+// [Hub.HandleWithRetry].
+type RetryHandler struct {
+	hub    *Hub
+	l      *listener
+	ch     <-chan *Event
+	fn     func(*Event) error
+	policy RetryPolicy
+
+	lk    sync.Mutex
+	queue []*Event
+	wake  chan struct{}
+	done  chan struct{}
+	ended chan struct{}
+	once  sync.Once
+}
+
+// HandleWithRetry calls fn for each event emitted on topic, which can be a pattern (see
+// [Hub.OnPattern]). When fn returns an error, it is called again with the same event
+// after the delay given by the policy, with [Event.Attempt] incremented. Retries stop
+// once the policy's maximum is reached or when the next attempt would happen after the
+// deadline of the event's context. The last error is then reported through [Hub.OnError],
+// and the event is dead-lettered if the topic has a dead-letter topic (see
+// [Hub.SetDeadLetter]). As emit returns once the event is queued, the cancellation of the
+// event's context is ignored, and fn receives a context only keeping its deadline.
+//
+// Events are handled one at a time, in order, from a dedicated goroutine. They are queued
+// during emit without blocking, so that an event being retried does not delay delivery
+// to the other listeners of the topic. When the queue is full, emit returns
+// [ErrRetryQueueFull] and the event is dead-lettered.
+func (h *Hub) HandleWithRetry(topic string, fn func(*Event) error, policy RetryPolicy) (*RetryHandler, error) {
+	if policy.Max == 0 {
+		policy.Max = DefaultRetryMax
+	}
+	if policy.Backoff == nil {
+		policy.Backoff = DefaultBackoff
+	}
+	if policy.Queue <= 0 {
+		policy.Queue = DefaultRetryQueue
+	}
+
+	r := &RetryHandler{
+		hub:    h,
+		l:      newListener(0),
+		fn:     fn,
+		policy: policy,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		ended:  make(chan struct{}),
+	}
+	r.ch = r.l.ch
+	r.l.push = func(ctx context.Context, ev *Event) error {
+		return r.enqueue(ev)
+	}
+	if err := h.attach(topic, r.l, r.ch); err != nil {
+		return nil, err
+	}
+
+	go r.run()
+	return r, nil
+}
+
+func (r *RetryHandler) enqueue(ev *Event) error {
+	r.lk.Lock()
+	if len(r.queue) >= r.policy.Queue {
+		r.lk.Unlock()
+		return ErrRetryQueueFull
+	}
+	r.queue = append(r.queue, ev)
+	r.lk.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next returns the next queued event, or nil if the queue is empty
+func (r *RetryHandler) next() *Event {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if len(r.queue) == 0 {
+		return nil
+	}
+	ev := r.queue[0]
+	r.queue[0] = nil
+	r.queue = r.queue[1:]
+	return ev
+}
+
+func (r *RetryHandler) run() {
+	defer close(r.ended)
+
+	for {
+		select {
+		case <-r.wake:
+		case <-r.done:
+			return
+		}
+		for ev := r.next(); ev != nil; ev = r.next() {
+			if !r.handle(ev) {
+				return
+			}
+		}
+	}
+}
+
+// handle calls fn with ev until it succeeds or retries are exhausted. It returns false
+// if the handler was closed meanwhile.
+func (r *RetryHandler) handle(ev *Event) bool {
+	select {
+	case <-r.done:
+		return false
+	default:
+	}
+
+	ev = ev.clone()
+	if ev.Context != nil {
+		// the context is usually cancelled as soon as emit returns
+		ctx := context.WithoutCancel(ev.Context)
+		if deadline, ok := ev.Context.Deadline(); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+		ev.Context = ctx
+	}
+	for attempt := 1; ; attempt += 1 {
+		ev.Attempt = attempt
+		err := r.fn(ev)
+		if err == nil {
+			return true
+		}
+
+		delay := r.policy.Backoff(attempt)
+		if r.policy.Max > 0 && attempt >= r.policy.Max {
+			r.fail(ev, err)
+			return true
+		}
+		if ev.Context != nil {
+			if deadline, ok := ev.Context.Deadline(); ok && time.Until(deadline) < delay {
+				r.fail(ev, err)
+				return true
+			}
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-r.done:
+			t.Stop()
+			return false
+		}
+	}
+}
+
+// fail reports the last error of ev and dead-letters it
+func (r *RetryHandler) fail(ev *Event, err error) {
+	topic := ev.current()
+	r.hub.root().reportError(fmt.Errorf("handler failed on topic %s after %d attempts: %w", topic, ev.Attempt, err))
+
+	t := r.hub.getTopic(topic, false)
+	if t == nil {
+		return
+	}
+	t.listenersLk.RLock()
+	dlq := t.deadLetter
+	t.listenersLk.RUnlock()
+
+	// deadLetter counts one failure, the previous attempts are added here
+	ev.attempts += ev.Attempt - 1
+	r.hub.deadLetter(dlq, ev, err, r.l)
+}
+
+// Close unsubscribes the handler, abandoning the events still queued or waiting for a
+// retry. It waits for a running call of the handler to return.
+func (r *RetryHandler) Close() error {
+	r.once.Do(func() {
+		r.hub.Unsubscribe(r.ch)
+		close(r.done)
+		<-r.ended
+	})
+	return nil
+}
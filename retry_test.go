@@ -0,0 +1,228 @@
+package emitter_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+var errTransient = errors.New("transient")
+
+func TestHandleWithRetry(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	var lk sync.Mutex
+	var calls []string
+	done := make(chan struct{})
+	r, err := h.HandleWithRetry("job/{id}", func(ev *emitter.Event) error {
+		lk.Lock()
+		defer lk.Unlock()
+		calls = append(calls, ev.Param("id"))
+		if ev.Param("id") == "1" && ev.Attempt < 3 {
+			return errTransient
+		}
+		if ev.Param("id") == "2" {
+			close(done)
+		}
+		return nil
+	}, emitter.Retry(5, emitter.ConstantBackoff(50*time.Millisecond)))
+	if err != nil {
+		t.Fatalf("failed to register handler: %s", err)
+	}
+	defer r.Close()
+
+	// an unbuffered listener on the same topic is not delayed by retries
+	ch := h.On("job/1")
+	go func() {
+		for range ch {
+		}
+	}()
+	start := time.Now()
+	if err := h.Emit(context.Background(), "job/1"); err != nil {
+		t.Fatalf("emit failed: %s", err)
+	}
+	if err := h.Emit(context.Background(), "job/1"); err != nil {
+		t.Fatalf("emit failed: %s", err)
+	}
+	if d := time.Since(start); d > 40*time.Millisecond {
+		t.Errorf("emit was blocked for %s", d)
+	}
+	h.Emit(context.Background(), "job/2")
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("events were not handled")
+	}
+
+	lk.Lock()
+	defer lk.Unlock()
+	expected := []string{"1", "1", "1", "1", "1", "1", "2"}
+	if len(calls) != len(expected) {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+	for n := range expected {
+		if calls[n] != expected[n] {
+			t.Fatalf("unexpected calls %v", calls)
+		}
+	}
+}
+
+func TestHandleWithRetryExhausted(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	errs := make(chan error, 1)
+	h.OnError = func(err error) { errs <- err }
+
+	h.SetDeadLetter("job", "job/dlq")
+	q := h.DeadLetters("job/dlq", 0)
+	defer q.Close()
+
+	r, _ := h.HandleWithRetry("job", func(ev *emitter.Event) error {
+		return errTransient
+	}, emitter.Retry(3, emitter.ConstantBackoff(time.Millisecond)))
+	defer r.Close()
+
+	h.Emit(context.Background(), "job", "x")
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, errTransient) {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("failure was not reported")
+	}
+	dl := waitDeadLetters(t, q, 1)[0]
+	if dl.Attempts != 3 || dl.Event.Arg(0) != "x" || !errors.Is(dl.Reason, errTransient) {
+		t.Errorf("unexpected dead letter %+v", dl)
+	}
+}
+
+func TestHandleWithRetryDeadline(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	errs := make(chan error, 1)
+	h.OnError = func(err error) { errs <- err }
+
+	var attempts int
+	r, _ := h.HandleWithRetry("job", func(ev *emitter.Event) error {
+		attempts = ev.Attempt
+		return errTransient
+	}, emitter.Retry(0, emitter.ConstantBackoff(40*time.Millisecond)))
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	h.Emit(ctx, "job")
+
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("failure was not reported")
+	}
+	if attempts < 2 || attempts > 3 {
+		t.Errorf("unexpected number of attempts %d", attempts)
+	}
+}
+
+func TestHandleWithRetryClose(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	calls := make(chan int, 10)
+	r, _ := h.HandleWithRetry("job", func(ev *emitter.Event) error {
+		calls <- ev.Attempt
+		return errTransient
+	}, emitter.Retry(0, emitter.ConstantBackoff(time.Hour)))
+
+	h.Emit(context.Background(), "job")
+	<-calls
+	r.Close()
+
+	if err := h.Emit(context.Background(), "job"); err != nil && err != emitter.ErrNoSuchTopic {
+		t.Errorf("emit failed: %s", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("handler called after close")
+	}
+}
+
+func TestHandleWithRetryLimits(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	errs := make(chan error, 10)
+	h.OnError = func(err error) { errs <- err }
+
+	// retries are bounded by default
+	attempts := make(chan int, 10)
+	r, _ := h.HandleWithRetry("job", func(ev *emitter.Event) error {
+		attempts <- ev.Attempt
+		return errTransient
+	}, emitter.Retry(0, emitter.ConstantBackoff(time.Millisecond)))
+	h.Emit(context.Background(), "job")
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("failure was not reported")
+	}
+	r.Close()
+	if len(attempts) != emitter.DefaultRetryMax {
+		t.Errorf("expected %d attempts, got %d", emitter.DefaultRetryMax, len(attempts))
+	}
+
+	// events are refused once the queue is full
+	started, release := make(chan struct{}), make(chan struct{})
+	r, _ = h.HandleWithRetry("queue", func(ev *emitter.Event) error {
+		if ev.Arg(0) == 1 {
+			close(started)
+			<-release
+		}
+		return nil
+	}, emitter.RetryPolicy{Queue: 1})
+	defer r.Close()
+	defer close(release)
+
+	h.Emit(context.Background(), "queue", 1)
+	<-started
+	if err := h.Emit(context.Background(), "queue", 2); err != nil {
+		t.Errorf("emit failed: %s", err)
+	}
+	if err := h.Emit(context.Background(), "queue", 3); err != emitter.ErrRetryQueueFull {
+		t.Errorf("expected ErrRetryQueueFull, got %v", err)
+	}
+}
+
+func TestHandleWithRetryEmitTimeout(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	done := make(chan int, 1)
+	r, _ := h.HandleWithRetry("job", func(ev *emitter.Event) error {
+		if ev.Attempt < 3 {
+			return errTransient
+		}
+		if _, ok := ev.Context.Deadline(); !ok || ev.Context.Err() != nil {
+			t.Errorf("unexpected context")
+		}
+		done <- ev.Attempt
+		return nil
+	}, emitter.Retry(5, emitter.ConstantBackoff(10*time.Millisecond)))
+	defer r.Close()
+
+	// the context of EmitTimeout is cancelled once the event is queued, not when it expires
+	h.EmitTimeout(time.Minute, "job")
+	select {
+	case n := <-done:
+		if n != 3 {
+			t.Errorf("unexpected number of attempts %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event was not retried")
+	}
+}
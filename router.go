@@ -0,0 +1,110 @@
+package emitter
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Router dispatches events to handlers registered with topic patterns. Unlike
+// [Hub.OnPattern], each event is handled only once, by the handler registered with
+// the most specific pattern matching the event's topic. A pattern is more specific
+// than another one if, comparing segments from left to right, it has a literal
+// segment where the other one has a parameter.
+//
+// Handlers are called sequentially from a single goroutine.
+type Router struct {
+	hub    *Hub
+	l      *listener
+	ch     <-chan *Event
+	routes []*route
+	lk     sync.RWMutex
+}
+
+type route struct {
+	pat *pattern
+	fn  func(*Event)
+}
+
+// NewRouter returns a new [Router] receiving events from this hub. Call [Router.Close]
+// once it isn't needed anymore.
+func (h *Hub) NewRouter() *Router {
+	l := newListener(h.Cap)
+	r := &Router{
+		hub: h,
+		l:   l,
+		ch:  l.ch,
+	}
+	// the router holds a reference on l until closed
+	atomic.AddInt32(&l.refs, 1)
+
+	go r.run()
+	return r
+}
+
+// Handle registers fn to handle events on topics matching pattern. See [Hub.OnPattern]
+// for the pattern syntax. Registering twice a pattern matching the same topics returns
+// [ErrPatternCollision].
+func (r *Router) Handle(pat string, fn func(*Event)) error {
+	p, err := parsePattern(r.hub.name(pat))
+	if err != nil {
+		return err
+	}
+
+	// the route is added before the pattern is registered, so every event received has a
+	// route to handle it
+	if err := r.addRoute(p, fn); err != nil {
+		return err
+	}
+
+	// lk must not be held while registering, as run needs it to receive pending events
+	r.hub.addPattern(p, r.l, r.ch)
+	return nil
+}
+
+func (r *Router) addRoute(p *pattern, fn func(*Event)) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	shape := p.shape()
+	for _, rt := range r.routes {
+		if rt.pat.shape() == shape {
+			return fmt.Errorf("%w: %q and %q", ErrPatternCollision, p.str, rt.pat.str)
+		}
+	}
+
+	r.routes = append(r.routes, &route{pat: p, fn: fn})
+	slices.SortStableFunc(r.routes, func(a, b *route) int {
+		return compareSpecificity(a.pat, b.pat)
+	})
+	return nil
+}
+
+// Close unregisters all of the router's patterns. Events already received may still
+// be dispatched to their handlers.
+func (r *Router) Close() {
+	r.hub.Unsubscribe(r.ch)
+	r.l.release()
+}
+
+func (r *Router) run() {
+	for ev := range r.ch {
+		if rt := r.lookup(ev.Topic); rt != nil {
+			rt.fn(ev)
+		}
+	}
+}
+
+// lookup returns the most specific route matching topic
+func (r *Router) lookup(topic string) *route {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+
+	for _, rt := range r.routes {
+		if rt.pat.match(topic) {
+			return rt
+		}
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+package emitter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSSEHeartbeat is the default interval between heartbeats sent by [SSEHandler].
+	DefaultSSEHeartbeat = 15 * time.Second
+
+	// DefaultSSEBuffer is the default number of events queued for each client of [SSEHandler].
+	DefaultSSEBuffer = 64
+
+	// DefaultSSEReplayTTL is the default value of [SSEOptions.ReplayTTL].
+	DefaultSSEReplayTTL = time.Minute
+)
+
+var errSSEClosed = errors.New("sse handler closed")
+
+var errSSENoTopics = errors.New("sse handler has no Topics function")
+
+// SSEOptions configures a handler created with [SSEHandler].
+type SSEOptions struct {
+	// Topics returns the topics a request subscribes to, which can be patterns (see
+	// [Hub.OnPattern]). It is required, and is responsible for authorizing the request:
+	// topics taken from the request, such as its query, must be checked before being
+	// returned. Requests without topics, or all requests if Topics is nil, are refused.
+	Topics func(r *http.Request) []string
+
+	// Heartbeat is the interval between heartbeat comments, which keep connections
+	// from being closed by proxies. It defaults to [DefaultSSEHeartbeat], and a
+	// negative value disables heartbeats.
+	Heartbeat time.Duration
+
+	// Replay is the number of recent events kept to be replayed to clients reconnecting
+	// with a Last-Event-ID header. If zero, events are not kept.
+	Replay int
+
+	// ReplayTTL is how long the listener of a topic is kept after its last client
+	// disconnected when Replay is set, so the events emitted meanwhile can be replayed
+	// to reconnecting clients. It defaults to [DefaultSSEReplayTTL].
+	ReplayTTL time.Duration
+
+	// Buffer is the number of events queued for each client, [DefaultSSEBuffer] if zero.
+	// Clients that fall further behind are disconnected.
+	Buffer int
+}
+
+// SSEServer is the [http.Handler] returned by [SSEHandler].
+type SSEServer struct {
+	hub    *Hub
+	opts   SSEOptions
+	lk     sync.Mutex
+	seq    uint64
+	ring   []*sseMessage // last events, oldest first
+	topics map[string]*sseTopic
+	closed bool
+}
+
+// sseTopic is a hub listener shared by all the clients subscribed to a topic
+type sseTopic struct {
+	key     string
+	ch      <-chan *Event
+	clients map[*sseClient]bool
+	idle    *time.Timer // removes the listener once it has no clients for ReplayTTL
+}
+
+type sseClient struct {
+	keys    []string
+	queue   chan *sseMessage
+	done    chan struct{} // closed once the client is detached from its topics
+	dropped bool
+}
+
+type sseMessage struct {
+	id    uint64
+	key   string // topic the message was received through
+	event string
+	data  []byte
+}
+
+// SSEHandler returns an [http.Handler] streaming events emitted on the hub as Server-Sent
+// Events. Each event is sent with the topic as event type and the arguments encoded as JSON
+// as data, a single argument being sent as is and several arguments as an array. Events are
+// numbered, and clients reconnecting with a Last-Event-ID header receive the events they
+// missed, provided [SSEOptions.Replay] is set and the events are still kept.
+//
+// Listeners are shared by all the clients of a given topic, so slow clients never block
+// emitters: a client falling more than [SSEOptions.Buffer] events behind is disconnected.
+// Listeners are removed once their last client disconnects, or [SSEOptions.ReplayTTL]
+// later if Replay is set.
+func SSEHandler(h *Hub, opts SSEOptions) *SSEServer {
+	return &SSEServer{
+		hub:    h,
+		opts:   opts,
+		topics: make(map[string]*sseTopic),
+	}
+}
+
+// ServeHTTP implements [http.Handler].
+func (s *SSEServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Topics == nil {
+		http.Error(w, errSSENoTopics.Error(), http.StatusInternalServerError)
+		return
+	}
+	keys := s.opts.Topics(r)
+	if len(keys) == 0 {
+		http.Error(w, "no topic", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	last, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	hasLast := err == nil
+
+	bufSize := s.opts.Buffer
+	if bufSize <= 0 {
+		bufSize = DefaultSSEBuffer
+	}
+	c := &sseClient{
+		queue: make(chan *sseMessage, bufSize),
+		done:  make(chan struct{}),
+	}
+	replay, err := s.register(c, keys, last, hasLast)
+	if err != nil {
+		if err == errSSEClosed {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	defer s.unregister(c)
+
+	hdr := w.Header()
+	hdr.Set("Content-Type", "text/event-stream")
+	hdr.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, msg := range replay {
+		if writeSSE(w, msg) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := s.opts.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = DefaultSSEHeartbeat
+	}
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		t := time.NewTicker(heartbeat)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case msg := <-c.queue:
+			if writeSSE(w, msg) != nil {
+				return
+			}
+		case <-tick:
+			if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+		case <-c.done:
+			// send what is left, typically when the hub was closed
+			for {
+				select {
+				case msg := <-c.queue:
+					if writeSSE(w, msg) != nil {
+						return
+					}
+				default:
+					flusher.Flush()
+					return
+				}
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSE(w http.ResponseWriter, msg *sseMessage) error {
+	buf := make([]byte, 0, len(msg.data)+len(msg.event)+32)
+	buf = append(buf, "id: "...)
+	buf = strconv.AppendUint(buf, msg.id, 10)
+	buf = append(buf, "\nevent: "...)
+	buf = append(buf, msg.event...)
+	buf = append(buf, '\n')
+	for _, line := range bytes.Split(msg.data, []byte("\n")) {
+		buf = append(buf, "data: "...)
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	buf = append(buf, '\n')
+	_, err := w.Write(buf)
+	return err
+}
+
+// register attaches c to the given topics, and returns the messages to replay
+func (s *SSEServer) register(c *sseClient, keys []string, last uint64, hasLast bool) ([]*sseMessage, error) {
+	// listeners are attached without holding s.lk, as pumps may be waiting for it during emit
+	created := make(map[string]<-chan *Event)
+	defer func() {
+		for _, ch := range created {
+			s.hub.Unsubscribe(ch)
+		}
+	}()
+
+	for {
+		s.lk.Lock()
+		if s.closed {
+			s.lk.Unlock()
+			return nil, errSSEClosed
+		}
+		var missing []string
+		for _, key := range keys {
+			if s.topics[key] == nil && created[key] == nil {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+		s.lk.Unlock()
+
+		for _, key := range missing {
+			var ch <-chan *Event
+			if IsPattern(key) {
+				var err error
+				if ch, err = s.hub.OnPattern(key); err != nil {
+					return nil, err
+				}
+			} else {
+				ch = s.hub.On(key)
+			}
+			created[key] = ch
+		}
+	}
+	defer s.lk.Unlock()
+
+	for _, key := range keys {
+		st := s.topics[key]
+		if st == nil {
+			st = &sseTopic{key: key, ch: created[key], clients: make(map[*sseClient]bool)}
+			delete(created, key)
+			s.topics[key] = st
+			go s.pump(st)
+		}
+		if st.idle != nil {
+			st.idle.Stop()
+			st.idle = nil
+		}
+		st.clients[c] = true
+		c.keys = append(c.keys, key)
+	}
+
+	var replay []*sseMessage
+	if hasLast {
+		for _, msg := range s.ring {
+			if msg.id > last && slices.Contains(keys, msg.key) {
+				replay = append(replay, msg)
+			}
+		}
+	}
+	return replay, nil
+}
+
+func (s *SSEServer) unregister(c *sseClient) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.drop(c)
+}
+
+// drop detaches c from its topics, must be called with s.lk held
+func (s *SSEServer) drop(c *sseClient) {
+	if c.dropped {
+		return
+	}
+	c.dropped = true
+	close(c.done)
+
+	for _, key := range c.keys {
+		st, ok := s.topics[key]
+		if !ok {
+			continue
+		}
+		delete(st.clients, c)
+		if len(st.clients) == 0 {
+			s.release(st)
+		}
+	}
+}
+
+// release removes the listener of st, or schedules its removal if events are kept for
+// replay. It must be called with s.lk held.
+func (s *SSEServer) release(st *sseTopic) {
+	if s.opts.Replay <= 0 {
+		s.remove(st)
+		return
+	}
+	ttl := s.opts.ReplayTTL
+	if ttl <= 0 {
+		ttl = DefaultSSEReplayTTL
+	}
+	var t *time.Timer
+	t = time.AfterFunc(ttl, func() {
+		s.lk.Lock()
+		defer s.lk.Unlock()
+		if st.idle == t && s.topics[st.key] == st {
+			s.remove(st)
+		}
+	})
+	st.idle = t
+}
+
+// remove removes the listener of st, it must be called with s.lk held
+func (s *SSEServer) remove(st *sseTopic) {
+	delete(s.topics, st.key)
+	// pumps may be waiting for s.lk during emit, which would block Unsubscribe
+	go s.hub.Unsubscribe(st.ch)
+}
+
+// pump reads events from the listener of st and dispatches them to its clients
+func (s *SSEServer) pump(st *sseTopic) {
+	for ev := range st.ch {
+		data, err := sseData(ev)
+		if err != nil {
+			s.hub.root().reportError(err)
+			continue
+		}
+
+		s.lk.Lock()
+		s.seq += 1
+		msg := &sseMessage{id: s.seq, key: st.key, event: s.hub.relative(ev.Topic), data: data}
+		if s.opts.Replay > 0 {
+			s.ring = append(s.ring, msg)
+			if len(s.ring) > s.opts.Replay {
+				s.ring = s.ring[1:]
+			}
+		}
+		for c := range st.clients {
+			select {
+			case c.queue <- msg:
+			default:
+				// slow client
+				s.drop(c)
+			}
+		}
+		s.lk.Unlock()
+	}
+
+	// the listener was closed, either by the hub or by the last client leaving
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.topics[st.key] == st {
+		delete(s.topics, st.key)
+	}
+	for c := range st.clients {
+		s.drop(c)
+	}
+}
+
+// sseData encodes the arguments of ev as JSON
+func sseData(ev *Event) ([]byte, error) {
+	if len(ev.Args) == 1 {
+		return ev.EncodedArg(0, "json", json.Marshal)
+	}
+	res := []byte{'['}
+	for n := range ev.Args {
+		if n > 0 {
+			res = append(res, ',')
+		}
+		buf, err := ev.EncodedArg(uint(n), "json", json.Marshal)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, buf...)
+	}
+	return append(res, ']'), nil
+}
+
+// Close disconnects all clients and removes all listeners.
+func (s *SSEServer) Close() error {
+	s.lk.Lock()
+	s.closed = true
+	topics := s.topics
+	s.topics = make(map[string]*sseTopic)
+	s.ring = nil
+	s.lk.Unlock()
+
+	for _, st := range topics {
+		s.hub.Unsubscribe(st.ch)
+	}
+	return nil
+}
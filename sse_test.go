@@ -0,0 +1,207 @@
+package emitter_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// sseConnect opens a stream on srv and returns a function reading the next event
+func sseConnect(t *testing.T, ctx context.Context, url, lastID string) func() sseEvent {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %s", resp.Status)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	r := bufio.NewReader(resp.Body)
+	return func() sseEvent {
+		var ev sseEvent
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read event: %s", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				if ev.id != "" {
+					return ev
+				}
+			case strings.HasPrefix(line, ":"):
+				return sseEvent{data: line}
+			default:
+				k, v, _ := strings.Cut(line, ": ")
+				switch k {
+				case "id":
+					ev.id = v
+				case "event":
+					ev.event = v
+				case "data":
+					ev.data = v
+				}
+			}
+		}
+	}
+}
+
+// queryTopics subscribes requests to the topics of their query, without any check
+func queryTopics(r *http.Request) []string {
+	return r.URL.Query()["topic"]
+}
+
+// waitNoTopic waits until topic has no listener, which makes commits fail
+func waitNoTopic(t *testing.T, ctx context.Context, h *emitter.Hub, topic string) {
+	t.Helper()
+	for {
+		tx := h.Begin()
+		tx.Emit(topic, "ping")
+		if tx.Commit(ctx) == emitter.ErrNoSuchTopic {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("listener was not removed after disconnect")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestSSE(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	// requests are refused until topics are authorized by a callback
+	rec := httptest.NewRecorder()
+	emitter.SSEHandler(h, emitter.SSEOptions{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?topic=chat", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected request to be refused, got %d", rec.Code)
+	}
+	sse := emitter.SSEHandler(h, emitter.SSEOptions{Heartbeat: -1, Topics: queryTopics})
+	defer sse.Close()
+	srv := httptest.NewServer(sse)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	next := sseConnect(t, ctx, srv.URL+"?topic=chat&topic=alert", "")
+	go h.Emit(ctx, "chat", map[string]string{"msg": "hello"})
+	if ev := next(); ev.id != "1" || ev.event != "chat" || ev.data != `{"msg":"hello"}` {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	go h.Emit(ctx, "alert", "fire", 2)
+	if ev := next(); ev.id != "2" || ev.event != "alert" || ev.data != `["fire",2]` {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected request without topic to fail, got %s", resp.Status)
+	}
+}
+
+func TestSSEHeartbeat(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	sse := emitter.SSEHandler(h, emitter.SSEOptions{Heartbeat: 10 * time.Millisecond, Topics: queryTopics})
+	srv := httptest.NewServer(sse)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	next := sseConnect(t, ctx, srv.URL+"?topic=chat", "")
+	if ev := next(); ev.data != ": heartbeat" {
+		t.Errorf("expected heartbeat, got %+v", ev)
+	}
+}
+
+func TestSSEReplay(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	sse := emitter.SSEHandler(h, emitter.SSEOptions{
+		Heartbeat: -1,
+		Replay:    10,
+		ReplayTTL: 100 * time.Millisecond,
+		Topics: func(r *http.Request) []string {
+			return []string{"room/" + r.PathValue("room")}
+		},
+	})
+	defer sse.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/rooms/{room}", sse)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cctx, ccancel := context.WithCancel(ctx)
+	next := sseConnect(t, cctx, srv.URL+"/rooms/a", "")
+	go h.Emit(ctx, "room/a", "one")
+	if ev := next(); ev.id != "1" || ev.data != `"one"` {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	ccancel()
+
+	// events emitted while disconnected are kept for replay
+	for _, msg := range []string{"two", "three"} {
+		if err := h.Emit(ctx, "room/a", msg); err != nil {
+			t.Fatalf("emit failed: %s", err)
+		}
+	}
+
+	cctx, ccancel = context.WithCancel(ctx)
+	next = sseConnect(t, cctx, srv.URL+"/rooms/a", "1")
+	for _, want := range []sseEvent{{"2", "room/a", `"two"`}, {"3", "room/a", `"three"`}} {
+		if ev := next(); ev != want {
+			t.Errorf("expected %+v, got %+v", want, ev)
+		}
+	}
+
+	// the listener is removed once it had no client for ReplayTTL
+	ccancel()
+	waitNoTopic(t, ctx, h, "room/a")
+}
+
+func TestSSEDisconnect(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	sse := emitter.SSEHandler(h, emitter.SSEOptions{Heartbeat: -1, Topics: queryTopics})
+	srv := httptest.NewServer(sse)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cctx, ccancel := context.WithCancel(ctx)
+	next := sseConnect(t, cctx, srv.URL+"?topic=user/{id}", "")
+	go h.Emit(ctx, "user/42", "login")
+	if ev := next(); ev.event != "user/42" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	ccancel()
+
+	// once the client is gone, the pattern is unsubscribed and new topics do not match it
+	waitNoTopic(t, ctx, h, "user/43")
+}
@@ -0,0 +1,221 @@
+package emitter
+
+import (
+	"context"
+	"sync"
+)
+
+// Stream operators transform channels such as the ones returned by [Hub.On]. Each operator
+// returns a channel which is closed once its input is closed or ctx is cancelled. The
+// operator then keeps draining its input, so that emitters are not blocked by a pipeline
+// nobody reads anymore, until the input is closed by unsubscribing it with [Hub.Off] or
+// [Hub.Unsubscribe].
+
+// Pair is a value of each of the channels given to [Zip].
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// drain reads from ch until it is closed
+func drain[T any](ch <-chan T) {
+	for range ch {
+	}
+}
+
+// pipe calls fn for each value of in, until in is closed, ctx is cancelled or fn returns
+// false. fn sends values to the returned channel with send, which returns false if ctx was
+// cancelled.
+func pipe[T, U any](ctx context.Context, in <-chan T, fn func(v T, send func(U) bool) bool) <-chan U {
+	out := make(chan U)
+	send := func(v U) bool {
+		select {
+		case out <- v:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer drain(in)
+		defer close(out)
+
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok || !fn(v, send) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Map returns a channel receiving fn(v) for each value v of in.
+func Map[T, U any](ctx context.Context, in <-chan T, fn func(T) U) <-chan U {
+	return pipe(ctx, in, func(v T, send func(U) bool) bool {
+		return send(fn(v))
+	})
+}
+
+// Filter returns a channel receiving the values of in for which fn returns true. To filter
+// events during emit, see [Hub.OnFilter].
+func Filter[T any](ctx context.Context, in <-chan T, fn func(T) bool) <-chan T {
+	return pipe(ctx, in, func(v T, send func(T) bool) bool {
+		return !fn(v) || send(v)
+	})
+}
+
+// Scan returns a channel receiving the successive values of an accumulator, starting at
+// init and updated with fn for each value of in.
+func Scan[T, S any](ctx context.Context, in <-chan T, init S, fn func(S, T) S) <-chan S {
+	acc := init
+	return pipe(ctx, in, func(v T, send func(S) bool) bool {
+		acc = fn(acc, v)
+		return send(acc)
+	})
+}
+
+// Reduce accumulates the values of in with fn, starting at init, and returns the result once
+// in is closed. If ctx is cancelled first, the value accumulated so far is returned with the
+// context's error.
+func Reduce[T, S any](ctx context.Context, in <-chan T, init S, fn func(S, T) S) (S, error) {
+	acc := init
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return acc, nil
+			}
+			acc = fn(acc, v)
+		case <-ctx.Done():
+			go drain(in)
+			return acc, ctx.Err()
+		}
+	}
+}
+
+// Distinct returns a channel receiving the values of in whose key, as returned by fn, was not
+// seen before. All the keys are kept in memory.
+func Distinct[T any, K comparable](ctx context.Context, in <-chan T, key func(T) K) <-chan T {
+	seen := make(map[K]struct{})
+	return pipe(ctx, in, func(v T, send func(T) bool) bool {
+		k := key(v)
+		if _, ok := seen[k]; ok {
+			return true
+		}
+		seen[k] = struct{}{}
+		return send(v)
+	})
+}
+
+// Take returns a channel receiving the first n values of in, which is closed afterwards.
+func Take[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	if n <= 0 {
+		out := make(chan T)
+		close(out)
+		go drain(in)
+		return out
+	}
+	cnt := 0
+	return pipe(ctx, in, func(v T, send func(T) bool) bool {
+		cnt += 1
+		return send(v) && cnt < n
+	})
+}
+
+// Skip returns a channel receiving the values of in except the first n ones.
+func Skip[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	cnt := 0
+	return pipe(ctx, in, func(v T, send func(T) bool) bool {
+		if cnt < n {
+			cnt += 1
+			return true
+		}
+		return send(v)
+	})
+}
+
+// Merge returns a channel receiving the values of all the given channels, which is closed
+// once they are all closed.
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+
+	for _, in := range ins {
+		wg.Add(1)
+		go func() {
+			// out may be closed as soon as wg is done, before in is
+			defer drain(in)
+			defer wg.Done()
+
+			for {
+				select {
+				case v, ok := <-in:
+					if !ok {
+						return
+					}
+					select {
+					case out <- v:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// Zip returns a channel receiving pairs made of the nth values of a and b, which is closed
+// once either of them is closed.
+func Zip[A, B any](ctx context.Context, a <-chan A, b <-chan B) <-chan Pair[A, B] {
+	out := make(chan Pair[A, B])
+
+	go func() {
+		defer func() {
+			go drain(b)
+			drain(a)
+		}()
+		defer close(out)
+
+		for {
+			var p Pair[A, B]
+			var ok bool
+			select {
+			case p.First, ok = <-a:
+			case <-ctx.Done():
+				return
+			}
+			if !ok {
+				return
+			}
+			select {
+			case p.Second, ok = <-b:
+			case <-ctx.Done():
+				return
+			}
+			if !ok {
+				return
+			}
+
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,171 @@
+package emitter_test
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// values sends the given values on a new channel and closes it
+func values[T any](vs ...T) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		for _, v := range vs {
+			ch <- v
+		}
+	}()
+	return ch
+}
+
+func TestStreamPipeline(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	ctx := context.Background()
+	base := runtime.NumGoroutine()
+
+	ch := h.On("numbers")
+	nums := emitter.Map(ctx, ch, func(ev *emitter.Event) int {
+		v, _ := emitter.Arg[int](ev, 0)
+		return v
+	})
+	evens := emitter.Filter(ctx, nums, func(v int) bool { return v%2 == 0 })
+	res := emitter.Take(ctx, emitter.Skip(ctx, evens, 1), 3)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := range 20 {
+			h.Emit(ctx, "numbers", n)
+		}
+	}()
+
+	var got []int
+	for v := range res {
+		got = append(got, v)
+	}
+	if !slices.Equal(got, []int{2, 4, 6}) {
+		t.Errorf("unexpected values %v", got)
+	}
+
+	// emitters are not blocked once the pipeline is done
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("emitter blocked by finished pipeline")
+	}
+
+	// all goroutines end once the topic is unsubscribed
+	h.Off("numbers", ch)
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > base {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked: %d > %d", runtime.NumGoroutine(), base)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStreamScanReduce(t *testing.T) {
+	ctx := context.Background()
+	sum := func(acc, v int) int { return acc + v }
+
+	var got []int
+	for v := range emitter.Scan(ctx, values(1, 2, 3, 4), 0, sum) {
+		got = append(got, v)
+	}
+	if !slices.Equal(got, []int{1, 3, 6, 10}) {
+		t.Errorf("unexpected scan %v", got)
+	}
+
+	if v, err := emitter.Reduce(ctx, values(1, 2, 3, 4), 0, sum); err != nil || v != 10 {
+		t.Errorf("unexpected reduce %d, %v", v, err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := emitter.Reduce(cctx, make(chan int), 0, sum); err != context.Canceled {
+		t.Errorf("expected cancelled reduce, got %v", err)
+	}
+}
+
+func TestStreamDistinct(t *testing.T) {
+	var got []string
+	in := values("a", "B", "A", "c", "b")
+	for v := range emitter.Distinct(context.Background(), in, func(s string) byte { return s[0] | 0x20 }) {
+		got = append(got, v)
+	}
+	if !slices.Equal(got, []string{"a", "B", "c"}) {
+		t.Errorf("unexpected values %v", got)
+	}
+}
+
+func TestStreamMergeZip(t *testing.T) {
+	ctx := context.Background()
+
+	var got []int
+	for v := range emitter.Merge(ctx, values(1, 2), values(3), values(4, 5)) {
+		got = append(got, v)
+	}
+	sort.Ints(got)
+	if !slices.Equal(got, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("unexpected merge %v", got)
+	}
+
+	var pairs []emitter.Pair[string, int]
+	for p := range emitter.Zip(ctx, values("a", "b", "c"), values(1, 2)) {
+		pairs = append(pairs, p)
+	}
+	if len(pairs) != 2 || pairs[0] != (emitter.Pair[string, int]{"a", 1}) || pairs[1] != (emitter.Pair[string, int]{"b", 2}) {
+		t.Errorf("unexpected zip %v", pairs)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := h.On("numbers")
+	out := emitter.Merge(ctx, emitter.Map(ctx, ch, func(ev *emitter.Event) any { return ev.Arg(0) }))
+	cancel()
+
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Errorf("unexpected value after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("output was not closed")
+	}
+	if err := h.EmitTimeout(time.Second, "numbers", 1); err != nil {
+		t.Errorf("emit blocked by cancelled pipeline: %v", err)
+	}
+}
+
+func TestStreamMergeCancel(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// the output is closed on cancel even though the inputs stay open
+	out := emitter.Merge(ctx, h.On("a"), h.On("b"))
+	cancel()
+
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Errorf("unexpected value after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("output was not closed")
+	}
+	if err := h.EmitTimeout(time.Second, "a", 1); err != nil {
+		t.Errorf("emit blocked by cancelled merge: %v", err)
+	}
+}
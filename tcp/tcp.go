@@ -0,0 +1,118 @@
+// Package tcp shares an [emitter.Hub] between machines over TCP, optionally secured with
+// TLS or mutual TLS. It uses the same protocol as the ipc package.
+//
+// On the server:
+//
+//	srv, err := tcp.Listen(hub, ":7400", tlsConfig)
+//	defer srv.Close()
+//
+// On clients, which reconnect automatically and restore their subscriptions:
+//
+//	r, err := tcp.Dial("server:7400", tcp.Options{TLS: tlsConfig})
+//	ch, err := r.On("topic")
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+	"github.com/KarpelesLab/emitter/ipc"
+)
+
+// DefaultDialTimeout is the default value of [Options.DialTimeout].
+const DefaultDialTimeout = 10 * time.Second
+
+// Server is a hub served over TCP.
+type Server struct {
+	*ipc.Server
+	l net.Listener
+}
+
+// Listen listens on the given TCP address and serves hub in the background. If config
+// is not nil, connections use TLS. Set config.ClientAuth to [tls.RequireAndVerifyClientCert]
+// to require clients to authenticate with a certificate.
+func Listen(hub *emitter.Hub, addr string, config *tls.Config) (*Server, error) {
+	var l net.Listener
+	var err error
+	if config != nil {
+		l, err = tls.Listen("tcp", addr, config)
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
+		Server: ipc.NewServer(hub),
+		l:      l,
+	}
+	go srv.Serve(l)
+	return srv, nil
+}
+
+// Addr returns the address the server is listening on.
+func (srv *Server) Addr() net.Addr {
+	return srv.l.Addr()
+}
+
+// Options configures a client created with [Dial].
+type Options struct {
+	// TLS, if not nil, is used to establish TLS connections. Set Certificates to
+	// authenticate to servers requiring client certificates.
+	TLS *tls.Config
+
+	// Codec is used to encode messages, and defaults to [emitter.JSONCodec].
+	Codec emitter.Codec
+
+	// Backoff is the delay between reconnection attempts, and defaults to
+	// [emitter.DefaultBackoff].
+	Backoff emitter.Backoff
+
+	// BufferSize is the maximum number of emits buffered while disconnected, and
+	// defaults to [ipc.DefaultBufferSize].
+	BufferSize int
+
+	// QueueSize is the maximum number of received events waiting to be read from the
+	// channel of each subscription. Further events are dropped, see
+	// [ipc.RemoteHub.Dropped]. Defaults to [ipc.DefaultQueueSize].
+	QueueSize int
+
+	// DialTimeout is the timeout of each connection attempt, and defaults to
+	// [DefaultDialTimeout].
+	DialTimeout time.Duration
+}
+
+// Dial connects to a hub served at addr. The initial connection must succeed, after which
+// the client reconnects automatically with exponential backoff whenever the connection is
+// lost, restoring its subscriptions. Emits made while disconnected are buffered up to
+// opts.BufferSize.
+func Dial(addr string, opts Options) (*ipc.RemoteHub, error) {
+	timeout := opts.DialTimeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+
+	dial := func(ctx context.Context) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		if opts.TLS != nil {
+			d := &tls.Dialer{Config: opts.TLS}
+			return d.DialContext(ctx, "tcp", addr)
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	return ipc.NewClient(dial, ipc.Options{
+		Codec:      opts.Codec,
+		Reconnect:  true,
+		Backoff:    opts.Backoff,
+		BufferSize: opts.BufferSize,
+		QueueSize:  opts.QueueSize,
+	})
+}
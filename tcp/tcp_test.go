@@ -0,0 +1,231 @@
+package tcp_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+	"github.com/KarpelesLab/emitter/tcp"
+)
+
+type testPKI struct {
+	pool   *x509.CertPool
+	server tls.Certificate
+	client tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTpl, caTpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDer)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) tls.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tpl, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("failed to create certificate: %v", err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &testPKI{
+		pool:   pool,
+		server: issue(2, x509.ExtKeyUsageServerAuth),
+		client: issue(3, x509.ExtKeyUsageClientAuth),
+	}
+}
+
+func TestTCP(t *testing.T) {
+	h := emitter.New()
+	srv, err := tcp.Listen(h, "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer srv.Close()
+
+	r, err := tcp.Dial(srv.Addr().String(), tcp.Options{})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer r.Close()
+
+	ch, err := r.OnWithCap("test", 1)
+	if err != nil {
+		t.Fatalf("On failed: %v", err)
+	}
+	if err := h.EmitTimeout(time.Second, "test", "hello"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	if ev := <-ch; ev.Arg(0) != "hello" {
+		t.Errorf("unexpected arg %v", ev.Arg(0))
+	}
+}
+
+func TestQueueSize(t *testing.T) {
+	h := emitter.New()
+	srv, err := tcp.Listen(h, "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer srv.Close()
+
+	r, err := tcp.Dial(srv.Addr().String(), tcp.Options{QueueSize: 1})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer r.Close()
+
+	// at most one event is waiting to be read and one is queued, the others are dropped
+	r.On("test")
+	for range 4 {
+		h.EmitTimeout(time.Second, "test")
+	}
+	deadline := time.Now().Add(time.Second)
+	for r.Dropped() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected dropped events, got %d", r.Dropped())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	h := emitter.New()
+
+	srv, err := tcp.Listen(h, "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer srv.Close()
+
+	local := h.OnWithCap("test", 1)
+
+	r, err := tcp.Dial(srv.Addr().String(), tcp.Options{TLS: &tls.Config{
+		RootCAs:      pki.pool,
+		Certificates: []tls.Certificate{pki.client},
+	}})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.Emit(ctx, "test", "secure"); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	if ev := <-local; ev.Arg(0) != "secure" {
+		t.Errorf("unexpected arg %v", ev.Arg(0))
+	}
+
+	// a client without certificate must be rejected
+	anon, err := tcp.Dial(srv.Addr().String(), tcp.Options{TLS: &tls.Config{RootCAs: pki.pool}})
+	if err == nil {
+		defer anon.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		if err := anon.Emit(ctx, "test", "anonymous"); err == nil {
+			t.Error("expected emit without client certificate to fail")
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	h := emitter.New()
+	srv, err := tcp.Listen(h, "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	addr := srv.Addr().String()
+
+	r, err := tcp.Dial(addr, tcp.Options{Backoff: emitter.ConstantBackoff(10 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer r.Close()
+
+	ch, err := r.OnWithCap("test", 10)
+	if err != nil {
+		t.Fatalf("On failed: %v", err)
+	}
+
+	// restart the server, the client must reconnect and resubscribe
+	srv.Close()
+
+	emitted := make(chan error, 1)
+	go func() {
+		// buffered while disconnected
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		emitted <- r.Emit(ctx, "buffered", "data")
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	buffered := h.OnWithCap("buffered", 1)
+	srv, err = tcp.Listen(h, addr, nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer srv.Close()
+
+	if err := <-emitted; err != nil {
+		t.Fatalf("buffered Emit failed: %v", err)
+	}
+	<-buffered
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := h.EmitTimeout(100*time.Millisecond, "test", "after")
+		if err == nil {
+			// topic may exist without the remote listener yet
+			select {
+			case ev := <-ch:
+				if ev.Arg(0) != "after" {
+					t.Errorf("unexpected arg %v", ev.Arg(0))
+				}
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription was not restored after reconnect")
+		}
+	}
+}
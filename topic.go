@@ -2,33 +2,108 @@ package emitter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"slices"
 	"sync"
+	"sync/atomic"
 )
 
 type topic struct {
+	hub         *Hub
 	listeners   map[<-chan *Event]*listener
 	listenersLk sync.RWMutex
+	deadLetter  string   // topic receiving undeliverable events, see Hub.SetDeadLetter
+	ordering    Ordering // see Hub.SetOrdering
+	dedup       *dedup   // see Hub.SetDedup
+	transient   bool     // not stored in the hub, only reached through patterns
+	name        string   // name of a transient topic
+
+	orderLk sync.Mutex          // held during emit with Strict ordering
+	keys    map[string]*keyLock // locks of keys being emitted with Keyed ordering
+	keysLk  sync.Mutex
+	txLk    sync.RWMutex // held exclusively while a transaction is committed
 }
 
-func newTopic() *topic {
+func newTopic(h *Hub) *topic {
 	res := &topic{
+		hub:       h,
 		listeners: make(map[<-chan *Event]*listener),
 	}
 	return res
 }
 
-func (t *topic) appendListener(l *listener) {
+// appendListener attaches l to this topic, and returns false if it was already attached
+func (t *topic) appendListener(l *listener, ch <-chan *Event) bool {
 	t.listenersLk.Lock()
 	defer t.listenersLk.Unlock()
-	t.listeners[l.ch] = l
+	if _, ok := t.listeners[ch]; ok {
+		return false
+	}
+	atomic.AddInt32(&l.refs, 1)
+	t.listeners[ch] = l
+	return true
+}
+
+// nameLock is the tx lock of a transient topic
+type nameLock struct {
+	lk   sync.RWMutex
+	refs int
+}
+
+// txLock returns the lock held exclusively while a transaction is committed, and a function
+// to call once it is not used anymore. Transient topics are not shared between emits, so
+// their lock is kept by the hub for as long as it is used.
+func (t *topic) txLock() (*sync.RWMutex, func()) {
+	if !t.transient {
+		return &t.txLk, func() {}
+	}
+
+	h := t.hub
+	h.txLksLk.Lock()
+	defer h.txLksLk.Unlock()
+	if h.txLks == nil {
+		h.txLks = make(map[string]*nameLock)
+	}
+	nl, ok := h.txLks[t.name]
+	if !ok {
+		nl = &nameLock{}
+		h.txLks[t.name] = nl
+	}
+	nl.refs += 1
+
+	return &nl.lk, func() {
+		h.txLksLk.Lock()
+		defer h.txLksLk.Unlock()
+		nl.refs -= 1
+		if nl.refs == 0 {
+			delete(h.txLks, t.name)
+		}
+	}
+}
+
+// listening returns true if the topic has listeners, directly or through patterns
+func (t *topic) listening(name string) bool {
+	t.listenersLk.RLock()
+	n := len(t.listeners)
+	t.listenersLk.RUnlock()
+	if n > 0 {
+		return true
+	}
+
+	h := t.hub.root()
+	h.topicsLk.RLock()
+	defer h.topicsLk.RUnlock()
+	return h.matchPattern(name)
 }
 
-func (t *topic) newListener(c uint) <-chan *Event {
+func (t *topic) newListener(c uint, filter func(*Event) bool) <-chan *Event {
 	l := newListener(c)
-	t.appendListener(l)
-	return l.ch
+	l.filter = filter
+	ch := l.ch // l.ch is reset on close, which may happen as soon as l is appended
+	t.appendListener(l, ch)
+	return ch
 }
 
 func (t *topic) takeAll() []*listener {
@@ -44,7 +119,73 @@ func (t *topic) takeAll() []*listener {
 	return res
 }
 
-func (t *topic) emit(ctx context.Context, ev *Event) (err error) {
+func (t *topic) emit(ctx context.Context, ev *Event) error {
+	// emits run concurrently, but not during the commit of a transaction
+	lk, release := t.txLock()
+	defer release()
+	lk.RLock()
+	defer lk.RUnlock()
+
+	return t.emitLocked(ctx, ev)
+}
+
+// emitLocked emits ev with txLk held
+func (t *topic) emitLocked(ctx context.Context, ev *Event) error {
+	dup, forget := t.duplicate(ev)
+	if dup {
+		return nil
+	}
+	err := t.deliver(ctx, ev)
+	if err != nil && forget != nil {
+		// let the emit be retried
+		forget()
+	}
+	return err
+}
+
+// deliver sends ev to the listeners of the topic
+func (t *topic) deliver(ctx context.Context, ev *Event) error {
+	unlock := t.lockOrder(ev)
+	defer unlock()
+
+	pats := t.hub.root().listenPatterns(ev.current())
+	defer releasePatterns(pats)
+
+	hooks, err := t.send(ctx, ev, pats)
+	if err != nil {
+		return err
+	}
+
+	// hooks are called once listenersLk has been released, as they may emit on other topics.
+	// A failing hook does not prevent the others from receiving the event.
+	var errs []error
+	for _, d := range hooks {
+		if err := d.l.push(ctx, d.ev); err != nil {
+			ev.report.add(0, 1, 0)
+			t.listenersLk.RLock()
+			t.hub.deadLetter(t.deadLetter, ev, err, d.l)
+			t.listenersLk.RUnlock()
+			errs = append(errs, err)
+			continue
+		}
+		ev.report.add(1, 0, 0)
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// delivery is an event to be delivered to a listener. Listeners attached through a pattern
+// receive their own copy of the event, so [Event.Param] uses their pattern.
+type delivery struct {
+	l  *listener
+	ev *Event
+}
+
+// send delivers ev to all the channel listeners of the topic and to the listeners of the
+// matching patterns, and returns the deliveries that need to be made through push hooks.
+func (t *topic) send(ctx context.Context, ev *Event, pats []*patternListener) (hooks []delivery, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			// most likely: panic: send on closed channel. Give up as we can't know exactly which channel caused this
@@ -56,13 +197,51 @@ func (t *topic) emit(ctx context.Context, ev *Event) (err error) {
 	t.listenersLk.RLock()
 	defer t.listenersLk.RUnlock()
 
-	if len(t.listeners) == 0 {
-		return nil
+	if len(t.listeners) == 0 && len(pats) == 0 {
+		t.hub.deadLetter(t.deadLetter, ev, ErrNoSuchTopic, nil)
+		return nil, nil
 	}
+	breaker := t.hub.Breaker
 
-	list := make([]*listener, 0, len(t.listeners))
+	all := make([]delivery, 0, len(t.listeners)+len(pats))
 	for _, l := range t.listeners {
-		list = append(list, l)
+		all = append(all, delivery{l, ev})
+	}
+	for _, pl := range pats {
+		// pats is sorted, so listeners get the most specific of their patterns
+		if _, ok := t.listeners[pl.ch]; ok || slices.ContainsFunc(all, func(d delivery) bool { return d.l == pl.l }) {
+			continue
+		}
+		cur := ev.clone()
+		cur.pattern = pl.pat
+		all = append(all, delivery{pl.l, cur})
+	}
+
+	list := make([]delivery, 0, len(all))
+	for _, d := range all {
+		l := d.l
+		ok, err := l.accept(d.ev)
+		if err != nil {
+			t.hub.reportError(err)
+			t.hub.deadLetter(t.deadLetter, ev, err, l)
+			ev.report.add(0, 1, 0)
+		}
+		if !ok {
+			continue
+		}
+		if l.push != nil {
+			hooks = append(hooks, d)
+			continue
+		}
+		if breaker != nil && !t.breakerAllow(breaker, l, ev) {
+			t.hub.deadLetter(t.deadLetter, ev, ErrBreakerOpen, l)
+			ev.report.add(0, 0, 1)
+			continue
+		}
+		list = append(list, d)
+	}
+	if len(list) == 0 {
+		return hooks, nil
 	}
 
 	cases := make([]reflect.SelectCase, len(list)+1)
@@ -74,10 +253,10 @@ func (t *topic) emit(ctx context.Context, ev *Event) (err error) {
 
 	n := 1
 
-	for _, l := range list {
+	for _, d := range list {
 		cases[n].Dir = reflect.SelectSend
-		cases[n].Chan = reflect.ValueOf(l.ch)
-		cases[n].Send = reflect.ValueOf(ev)
+		cases[n].Chan = reflect.ValueOf(d.l.ch)
+		cases[n].Send = reflect.ValueOf(d.ev)
 		n += 1
 	}
 
@@ -87,13 +266,34 @@ func (t *topic) emit(ctx context.Context, ev *Event) (err error) {
 		// (chosen int, recv Value, recvOK bool)
 		chosen, _, _ := reflect.Select(cases)
 		if chosen == 0 {
-			// ctx.Done()
-			return ctx.Err()
+			// ctx.Done(), listeners that did not receive the event yet are dead-lettered
+			for n, d := range list {
+				l := d.l
+				if cases[n+1].Chan.IsValid() {
+					t.hub.deadLetter(t.deadLetter, ev, ctx.Err(), l)
+					ev.report.add(0, 1, 0)
+					if breaker != nil {
+						t.breakerDone(breaker, l, ev, ctx.Err())
+					}
+					continue
+				}
+				ev.report.add(1, 0, 0)
+				if breaker != nil {
+					t.breakerDone(breaker, l, ev, nil)
+				}
+			}
+			return nil, ctx.Err()
 		}
 		cnt -= 1
 		if cnt == 0 {
 			// all sends completed successfully
-			return nil
+			ev.report.add(len(list), 0, 0)
+			if breaker != nil {
+				for _, d := range list {
+					t.breakerDone(breaker, d.l, ev, nil)
+				}
+			}
+			return hooks, nil
 		}
 		// set to nil & continue
 		cases[chosen].Chan = reflect.Value{}
@@ -103,7 +303,7 @@ func (t *topic) emit(ctx context.Context, ev *Event) (err error) {
 func (t *topic) close() {
 	ls := t.takeAll()
 	for _, l := range ls {
-		go l.close()
+		l.release()
 	}
 }
 
@@ -113,6 +313,6 @@ func (t *topic) remove(ch <-chan *Event) {
 
 	if l, ok := t.listeners[ch]; ok {
 		delete(t.listeners, ch)
-		go l.close()
+		l.release()
 	}
 }
@@ -0,0 +1,224 @@
+package emitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxReserveDelay is the longest interval between two checks of [Tx.reserve]
+const maxReserveDelay = 20 * time.Millisecond
+
+// ErrTxDone is returned when using a transaction that was already committed or rolled back.
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// TxError is returned by [Tx.Commit] when the delivery of an event failed after the
+// previous ones had been delivered.
+type TxError struct {
+	Delivered int // number of events delivered to all their listeners
+	Err       error
+}
+
+func (e *TxError) Error() string {
+	return fmt.Sprintf("transaction failed after %d delivered events: %s", e.Delivered, e.Err)
+}
+
+func (e *TxError) Unwrap() error {
+	return e.Err
+}
+
+// Tx stages events to be emitted together on one or more topics, see [Hub.Begin].
+type Tx struct {
+	hub    *Hub
+	lk     sync.Mutex
+	events []*Event
+	done   bool
+}
+
+// Begin starts a transaction. Events emitted on the transaction are staged until
+// [Tx.Commit] delivers them all, or [Tx.Rollback] discards them.
+func (h *Hub) Begin() *Tx {
+	return &Tx{hub: h}
+}
+
+// Emit stages an event on topic.
+func (tx *Tx) Emit(topic string, args ...any) error {
+	return tx.EmitEvent(topic, &Event{Args: args})
+}
+
+// EmitEvent stages an existing event on topic.
+func (tx *Tx) EmitEvent(topic string, ev *Event) error {
+	tx.lk.Lock()
+	defer tx.lk.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+	ev.Topic = tx.hub.name(topic)
+	tx.events = append(tx.events, ev)
+	return nil
+}
+
+// Rollback discards the staged events.
+func (tx *Tx) Rollback() error {
+	tx.lk.Lock()
+	defer tx.lk.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.events = nil
+	return nil
+}
+
+// Commit delivers the staged events in order. During the commit, other emits on the
+// transaction's topics wait, so listeners never observe other events in between. If one of
+// the topics has no listener, Commit returns [ErrNoSuchTopic] without delivering anything.
+// Events are only delivered to their topic, [Hub.Bubble] is ignored.
+//
+// Before delivering anything, Commit waits until the buffered channel listeners have room
+// for all the events they would receive, and returns the error of ctx if it expires
+// meanwhile. Other emits are not blocked while Commit waits. Events rejected by a filter are counted as received by this check.
+//
+// Events received by listeners cannot be taken back: if delivering an event fails, Commit
+// returns a [*TxError] with the number of events delivered to all their listeners. This
+// can happen when ctx expires while delivering to an unbuffered listener, a listener with
+// less capacity than the events it would receive, or a listener also receiving events from
+// topics outside the transaction, and when a push hook such as [Hub.OnPriority] or
+// [Hub.HandleWithRetry] fails.
+func (tx *Tx) Commit(ctx context.Context) error {
+	tx.lk.Lock()
+	if tx.done {
+		tx.lk.Unlock()
+		return ErrTxDone
+	}
+	tx.done = true
+	events := tx.events
+	tx.events = nil
+	tx.lk.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// topics are locked in a consistent order, so concurrent commits cannot deadlock
+	names := make([]string, 0, len(events))
+	for _, ev := range events {
+		names = append(names, ev.Topic)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	topics := make(map[string]*topic, len(names))
+	for _, name := range names {
+		t := tx.hub.getTopic(name, false)
+		if t == nil {
+			return ErrNoSuchTopic
+		}
+		topics[name] = t
+	}
+	for {
+		// wait for room without blocking other emits, then check again with the locks held
+		if err := tx.reserve(ctx, events, topics); err != nil {
+			return err
+		}
+		if ok, err := tx.deliver(ctx, names, events, topics); ok {
+			return err
+		}
+	}
+}
+
+// deliver locks the topics and delivers events, and returns false without delivering
+// anything if a buffered listener does not have room for them anymore
+func (tx *Tx) deliver(ctx context.Context, names []string, events []*Event, topics map[string]*topic) (bool, error) {
+	for _, name := range names {
+		lk, release := topics[name].txLock()
+		defer release()
+		lk.Lock()
+		defer lk.Unlock()
+	}
+	for _, name := range names {
+		if !topics[name].listening(name) {
+			return true, ErrNoSuchTopic
+		}
+	}
+	if full(tx.need(events, topics)) {
+		return false, nil
+	}
+
+	for n, ev := range events {
+		if ev.Context == nil {
+			ev.Context = ctx
+		}
+		ev.CurrentTopic = ev.Topic
+		if err := topics[ev.Topic].emitLocked(ctx, ev); err != nil {
+			return true, &TxError{Delivered: n, Err: err}
+		}
+	}
+	return true, nil
+}
+
+// reserve waits until the buffered channel listeners of topics have room for the events
+// they would receive. Receiving from a channel cannot be waited for, so it is checked at
+// growing intervals.
+func (tx *Tx) reserve(ctx context.Context, events []*Event, topics map[string]*topic) error {
+	var timer *time.Timer
+	for delay := time.Millisecond; full(tx.need(events, topics)); delay = min(2*delay, maxReserveDelay) {
+		if timer == nil {
+			timer = time.NewTimer(delay)
+			defer timer.Stop()
+		} else {
+			timer.Reset(delay)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// need returns the number of events each buffered channel listener of topics would receive
+func (tx *Tx) need(events []*Event, topics map[string]*topic) map[chan *Event]int {
+	need := make(map[chan *Event]int)
+	for _, ev := range events {
+		t := topics[ev.Topic]
+		pats := tx.hub.root().listenPatterns(ev.Topic)
+		t.listenersLk.RLock()
+		seen := make(map[*listener]bool, len(t.listeners)+len(pats))
+		for _, l := range t.listeners {
+			seen[l] = true
+		}
+		for _, pl := range pats {
+			seen[pl.l] = true
+		}
+		for l := range seen {
+			// l.ch is reset once l is closed, which cannot happen while it is attached
+			if l.push == nil && cap(l.ch) > 0 {
+				need[l.ch] += 1
+			}
+		}
+		t.listenersLk.RUnlock()
+		releasePatterns(pats)
+	}
+	return need
+}
+
+// full returns true if a channel does not have room for the events it needs. Channels
+// with less capacity than needed are ignored, since they can never have enough room.
+func full(need map[chan *Event]int) bool {
+	for ch, n := range need {
+		if n <= cap(ch) && cap(ch)-len(ch) < n {
+			return true
+		}
+	}
+	return false
+}
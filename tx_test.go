@@ -0,0 +1,252 @@
+package emitter_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+func TestTxCommit(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	a := h.OnWithCap("a", 2)
+	b := h.OnWithCap("b", 1)
+
+	tx := h.Begin()
+	tx.Emit("a", 1)
+	tx.Emit("b", 2)
+	tx.Emit("a", 3)
+	if len(a) != 0 || len(b) != 0 {
+		t.Fatalf("staged events were delivered before commit")
+	}
+	if err := tx.Commit(context.Background()); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	if len(a) != 2 || len(b) != 1 {
+		t.Fatalf("unexpected delivery: %d, %d", len(a), len(b))
+	}
+	if v := (<-a).Arg(0); v != 1 {
+		t.Errorf("unexpected first event %v", v)
+	}
+	if ev := <-b; ev.Topic != "b" || ev.Arg(0) != 2 {
+		t.Errorf("unexpected event %s %v", ev.Topic, ev.Args)
+	}
+
+	if err := tx.Emit("a", 4); err != emitter.ErrTxDone {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+	if err := tx.Commit(context.Background()); err != emitter.ErrTxDone {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	a := h.OnWithCap("a", 1)
+
+	tx := h.Begin()
+	tx.Emit("a", 1)
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback failed: %s", err)
+	}
+	if err := tx.Commit(context.Background()); err != emitter.ErrTxDone {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+	if err := tx.Rollback(); err != emitter.ErrTxDone {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+	if len(a) != 0 {
+		t.Errorf("rolled back event was delivered")
+	}
+}
+
+func TestTxNoSuchTopic(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	a := h.OnWithCap("a", 1)
+	h.Off("b", h.On("b")) // topic exists without listener
+
+	for _, topic := range []string{"b", "missing"} {
+		tx := h.Begin()
+		tx.Emit("a", 1)
+		tx.Emit(topic, 2)
+		if err := tx.Commit(context.Background()); err != emitter.ErrNoSuchTopic {
+			t.Errorf("expected ErrNoSuchTopic for %s, got %v", topic, err)
+		}
+	}
+	if len(a) != 0 {
+		t.Errorf("events were delivered by a failed commit")
+	}
+}
+
+func TestTxPartial(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	h.OnWithCap("a", 1)
+	h.On("b") // never read
+
+	tx := h.Begin()
+	tx.Emit("a", 1)
+	tx.Emit("b", 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := tx.Commit(ctx)
+	var txErr *emitter.TxError
+	if !errors.As(err, &txErr) || txErr.Delivered != 1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestTxReserve(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	a := h.OnWithCap("a", 1)
+	b := h.OnWithCap("b", 1)
+	h.Emit(context.Background(), "a", 0) // a is full
+
+	// nothing is delivered unless all the buffered listeners have room
+	tx := h.Begin()
+	tx.Emit("b", 1)
+	tx.Emit("a", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var txErr *emitter.TxError
+	if err := tx.Commit(ctx); err != context.DeadlineExceeded || errors.As(err, &txErr) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if len(b) != 0 {
+		t.Errorf("events were delivered by a failed commit")
+	}
+
+	// the commit waits for room
+	tx = h.Begin()
+	tx.Emit("b", 2)
+	tx.Emit("a", 2)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-a
+	}()
+	if err := tx.Commit(context.Background()); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	if ev := <-a; ev.Arg(0) != 2 || len(b) != 1 {
+		t.Errorf("unexpected delivery of %v", ev.Arg(0))
+	}
+}
+
+func TestTxAtomic(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	ctx := context.Background()
+
+	const txs, noise = 200, 1000
+	ch := h.OnMany([]string{"a", "b"}, 0)
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for n := range txs {
+			tx := h.Begin()
+			tx.Emit("a", n)
+			tx.Emit("b", n)
+			if err := tx.Commit(ctx); err != nil {
+				t.Errorf("commit failed: %s", err)
+			}
+		}
+	}()
+	for _, topic := range []string{"a", "b"} {
+		go func() {
+			defer wg.Done()
+			for range noise {
+				h.Emit(ctx, topic, -1)
+			}
+		}()
+	}
+
+	// the two events of each transaction are received next to each other
+	var prev *emitter.Event
+	split := 0
+	for range 2*txs + 2*noise {
+		ev := <-ch
+		n, _ := emitter.Arg[int](ev, 0)
+		switch {
+		case prev != nil:
+			if ev.Topic != "b" || n != prev.Arg(0) {
+				split += 1
+			}
+			prev = nil
+		case n >= 0 && ev.Topic == "a":
+			prev = ev
+		case n >= 0:
+			split += 1
+		}
+	}
+	wg.Wait()
+	if split > 0 {
+		t.Errorf("%d transactions were interleaved with other events", split)
+	}
+}
+
+func TestTxPattern(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+
+	h.Cap = 2
+	ch, _ := h.OnPattern("user/{id}")
+	tx := h.Begin()
+	tx.Emit("user/1", "a")
+	tx.Emit("user/2", "b")
+	if err := tx.Commit(context.Background()); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+
+	for _, id := range []string{"1", "2"} {
+		if ev := <-ch; ev.Param("id") != id {
+			t.Errorf("unexpected event on %s", ev.Topic)
+		}
+	}
+}
+
+func TestTxWaitNotBlocking(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	ctx := context.Background()
+
+	h.Cap = 1
+	x, _ := h.OnPattern("x/{id}")
+	y, _ := h.OnPattern("y/{id}")
+	h.Emit(ctx, "x/1", 0) // x is full
+
+	// a commit waiting for room does not block emits on other topics
+	tx := h.Begin()
+	tx.Emit("x/1", 1)
+	done := make(chan error, 1)
+	go func() { done <- tx.Commit(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	if err := h.EmitTimeout(100*time.Millisecond, "y/1", 1); err != nil {
+		t.Errorf("emit blocked by a waiting commit: %s", err)
+	}
+	if ev := <-y; ev.Arg(0) != 1 {
+		t.Errorf("unexpected event %v", ev.Arg(0))
+	}
+
+	<-x
+	if err := <-done; err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	if ev := <-x; ev.Arg(0) != 1 {
+		t.Errorf("unexpected event %v", ev.Arg(0))
+	}
+}
@@ -0,0 +1,373 @@
+// Package webhook delivers events emitted on an [emitter.Hub] to HTTP endpoints, with
+// at-least-once semantics.
+//
+// Each event is POSTed as a CloudEvents 1.0 JSON document, signed following the Standard
+// Webhooks specification when a secret is configured:
+//
+//	sink, err := webhook.Attach(hub, "order/{id}/paid", "https://example.com/hook", webhook.Options{
+//		Secret:     secret,
+//		DeadLetter: "webhook/failed",
+//	})
+//	defer sink.Close()
+//
+// Failed deliveries are retried with exponential backoff and jitter. Events that still
+// could not be delivered after [Options.MaxAttempts] are emitted on the dead-letter topic.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+)
+
+// Headers of webhook requests, as defined by Standard Webhooks.
+const (
+	HeaderID        = "Webhook-Id"
+	HeaderTimestamp = "Webhook-Timestamp"
+	HeaderSignature = "Webhook-Signature"
+)
+
+// Headers set on events emitted on the dead-letter topic.
+const (
+	HeaderError    = "Webhook-Error"
+	HeaderAttempts = "Webhook-Attempts"
+)
+
+// Default values of [Options].
+const (
+	DefaultMaxAttempts = 8
+	DefaultConcurrency = 4
+	DefaultTimeout     = 30 * time.Second
+)
+
+// DefaultTolerance is the maximum age of a request accepted by [Verify].
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	// ErrClosed is the reason given for events that could not be delivered because the
+	// sink was closed.
+	ErrClosed = errors.New("webhook: sink closed")
+
+	// ErrInvalidSignature is returned by [Verify] when a request is not properly signed.
+	ErrInvalidSignature = errors.New("webhook: invalid signature")
+)
+
+// StatusError is returned when an endpoint responds with a non-2xx status.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook: unexpected status %d", e.StatusCode)
+}
+
+// temporary returns true if the request may succeed if retried
+func (e *StatusError) temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout
+}
+
+// Options configures a sink created with [Attach].
+type Options struct {
+	// Secret is used to sign requests with HMAC-SHA256. If nil, requests are not signed.
+	Secret []byte
+
+	// Source is the CloudEvents source attribute, "emitter" by default.
+	Source string
+
+	// Client is the HTTP client used to send requests. By default, a client with a
+	// [DefaultTimeout] timeout is used.
+	Client *http.Client
+
+	// MaxAttempts is the number of delivery attempts of each event, including the first
+	// one, and defaults to [DefaultMaxAttempts].
+	MaxAttempts int
+
+	// Backoff returns the delay before retrying, [emitter.DefaultBackoff] by default. A
+	// Retry-After header sent by the endpoint can increase it.
+	Backoff emitter.Backoff
+
+	// Concurrency is the maximum number of simultaneous requests to the endpoint, and
+	// defaults to [DefaultConcurrency]. Once reached, the listener stops receiving, which
+	// applies backpressure to emitters.
+	Concurrency int
+
+	// DeadLetter, if set, is the topic on which events that could not be delivered are
+	// emitted, with the reason and number of attempts in the [HeaderError] and
+	// [HeaderAttempts] headers.
+	DeadLetter string
+
+	// Cap is the capacity of the listener, see [emitter.Hub.OnWithCap].
+	Cap uint
+}
+
+// Sink delivers the events of a topic to an HTTP endpoint, see [Attach].
+type Sink struct {
+	hub    *emitter.Hub
+	url    string
+	opts   Options
+	ch     <-chan *emitter.Event
+	sem    chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// Attach subscribes to topic, which can be a pattern (see [emitter.Hub.OnPattern]), and
+// POSTs each event to url. Delivery happens in the background until [Sink.Close] is called.
+func Attach(hub *emitter.Hub, topic, url string, opts Options) (*Sink, error) {
+	if opts.Source == "" {
+		opts.Source = "emitter"
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: DefaultTimeout}
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = emitter.DefaultBackoff
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+
+	s := &Sink{
+		hub:  hub,
+		url:  url,
+		opts: opts,
+		sem:  make(chan struct{}, opts.Concurrency),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	if emitter.IsPattern(topic) {
+		ch, err := hub.OnPattern(topic)
+		if err != nil {
+			return nil, err
+		}
+		s.ch = ch
+	} else {
+		s.ch = hub.OnWithCap(topic, opts.Cap)
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+func (s *Sink) run() {
+	defer s.wg.Done()
+
+	for ev := range s.ch {
+		select {
+		case s.sem <- struct{}{}:
+		case <-s.ctx.Done():
+			s.deadLetter(ev, ErrClosed, 0)
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() { <-s.sem }()
+			s.deliver(ev)
+		}()
+	}
+}
+
+// deliver sends ev until it succeeds, fails permanently or runs out of attempts
+func (s *Sink) deliver(ev *emitter.Event) {
+	body, err := encode(ev, s.opts.Source)
+	if err != nil {
+		s.deadLetter(ev, err, 0)
+		return
+	}
+	id := body.ID
+
+	buf, err := json.Marshal(body)
+	if err != nil {
+		s.deadLetter(ev, err, 0)
+		return
+	}
+
+	for attempt := 1; ; attempt += 1 {
+		retryAfter, err := s.send(id, buf)
+		if err == nil {
+			return
+		}
+		var se *StatusError
+		if (errors.As(err, &se) && !se.temporary()) || attempt >= s.opts.MaxAttempts {
+			s.deadLetter(ev, err, attempt)
+			return
+		}
+
+		t := time.NewTimer(max(s.opts.Backoff(attempt), retryAfter))
+		select {
+		case <-t.C:
+		case <-s.ctx.Done():
+			t.Stop()
+			s.deadLetter(ev, ErrClosed, attempt)
+			return
+		}
+	}
+}
+
+// send performs a single delivery attempt, and returns the delay requested by the
+// endpoint through Retry-After, if any
+func (s *Sink) send(id string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	req.Header.Set(HeaderID, id)
+	req.Header.Set(HeaderTimestamp, ts)
+	if s.opts.Secret != nil {
+		req.Header.Set(HeaderSignature, "v1,"+sign(s.opts.Secret, id, ts, body))
+	}
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, nil
+	}
+
+	var retryAfter time.Duration
+	if n, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && n > 0 {
+		retryAfter = time.Duration(n) * time.Second
+	}
+	return retryAfter, &StatusError{StatusCode: resp.StatusCode}
+}
+
+// deadLetter emits ev on the dead-letter topic, if any
+func (s *Sink) deadLetter(ev *emitter.Event, reason error, attempts int) {
+	if s.opts.DeadLetter == "" {
+		return
+	}
+	header := make(map[string]string, len(ev.Header)+2)
+	for k, v := range ev.Header {
+		header[k] = v
+	}
+	header[HeaderError] = reason.Error()
+	header[HeaderAttempts] = strconv.Itoa(attempts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	s.hub.EmitEvent(ctx, s.opts.DeadLetter, &emitter.Event{
+		Context: ctx,
+		Args:    ev.Args,
+		Header:  header,
+	})
+}
+
+// Close stops receiving events and cancels pending deliveries, which are sent to the
+// dead-letter topic. It returns once all deliveries have ended.
+func (s *Sink) Close() error {
+	s.once.Do(func() {
+		s.cancel()
+		s.hub.Unsubscribe(s.ch)
+		s.wg.Wait()
+	})
+	return nil
+}
+
+// cloudEvent is a CloudEvents 1.0 document in structured JSON mode
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// encode returns the CloudEvents representation of ev. The event type is the topic, and
+// data is the argument encoded as JSON, or an array if there are several arguments. The
+// id is the ID of the event, or a random one if it has none.
+func encode(ev *emitter.Event, source string) (*cloudEvent, error) {
+	var data []byte
+	if len(ev.Args) == 1 {
+		buf, err := ev.EncodedArg(0, "json", json.Marshal)
+		if err != nil {
+			return nil, err
+		}
+		data = buf
+	} else {
+		data = []byte{'['}
+		for n := range ev.Args {
+			if n > 0 {
+				data = append(data, ',')
+			}
+			buf, err := ev.EncodedArg(uint(n), "json", json.Marshal)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, buf...)
+		}
+		data = append(data, ']')
+	}
+
+	id := ev.ID
+	if id == "" {
+		buf := make([]byte, 16)
+		rand.Read(buf)
+		id = hex.EncodeToString(buf)
+	}
+	return &cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Source:          source,
+		Type:            ev.Topic,
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
+
+func sign(secret []byte, id, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id + "." + ts + "."))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a webhook request with the given headers and body, as
+// received by an endpoint. Requests older than [DefaultTolerance] are refused.
+func Verify(secret []byte, header http.Header, body []byte) error {
+	id := header.Get(HeaderID)
+	ts := header.Get(HeaderTimestamp)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || id == "" {
+		return ErrInvalidSignature
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > DefaultTolerance || d < -DefaultTolerance {
+		return ErrInvalidSignature
+	}
+
+	expected := sign(secret, id, ts, body)
+	for _, sig := range strings.Fields(header.Get(HeaderSignature)) {
+		if v, ok := strings.CutPrefix(sig, "v1,"); ok && hmac.Equal([]byte(v), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
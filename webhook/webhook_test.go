@@ -0,0 +1,226 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+	"github.com/KarpelesLab/emitter/webhook"
+)
+
+var fastRetry = emitter.Jitter(emitter.ConstantBackoff(5 * time.Millisecond))
+
+func TestDelivery(t *testing.T) {
+	secret := []byte("s3cret")
+	received := make(chan map[string]any, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify(secret, r.Header, body); err != nil {
+			t.Errorf("invalid signature: %s", err)
+		}
+		if err := webhook.Verify([]byte("other"), r.Header, body); err == nil {
+			t.Errorf("signature verified with the wrong secret")
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/cloudevents+json" {
+			t.Errorf("unexpected content type %s", ct)
+		}
+		var ce map[string]any
+		json.Unmarshal(body, &ce)
+		received <- ce
+	}))
+	defer srv.Close()
+
+	h := emitter.New()
+	defer h.Close()
+	sink, err := webhook.Attach(h, "order/{id}/paid", srv.URL, webhook.Options{Secret: secret, Source: "shop"})
+	if err != nil {
+		t.Fatalf("attach failed: %s", err)
+	}
+	defer sink.Close()
+
+	if err := h.EmitTimeout(time.Second, "order/42/paid", map[string]any{"amount": 100}); err != nil {
+		t.Fatalf("emit failed: %s", err)
+	}
+
+	select {
+	case ce := <-received:
+		if ce["specversion"] != "1.0" || ce["type"] != "order/42/paid" || ce["source"] != "shop" || ce["id"] == "" {
+			t.Errorf("unexpected cloud event %v", ce)
+		}
+		if data, _ := ce["data"].(map[string]any); data["amount"] != float64(100) {
+			t.Errorf("unexpected data %v", ce["data"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event was not delivered")
+	}
+}
+
+func TestEventID(t *testing.T) {
+	received := make(chan [2]string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ce map[string]any
+		json.NewDecoder(r.Body).Decode(&ce)
+		id, _ := ce["id"].(string)
+		received <- [2]string{r.Header.Get(webhook.HeaderID), id}
+	}))
+	defer srv.Close()
+
+	h := emitter.New()
+	defer h.Close()
+	sink, err := webhook.Attach(h, "topic", srv.URL, webhook.Options{})
+	if err != nil {
+		t.Fatalf("attach failed: %s", err)
+	}
+	defer sink.Close()
+
+	// the id of the event is kept, so endpoints can drop duplicates
+	h.EmitEvent(context.Background(), "topic", &emitter.Event{ID: "order-42", Args: []any{"paid"}})
+	select {
+	case ids := <-received:
+		if ids[0] != "order-42" || ids[1] != "order-42" {
+			t.Errorf("unexpected ids %v", ids)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event was not delivered")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var attempts atomic.Int32
+	ids := make(chan string, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids <- r.Header.Get(webhook.HeaderID)
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	h := emitter.New()
+	defer h.Close()
+	sink, err := webhook.Attach(h, "topic", srv.URL, webhook.Options{Backoff: fastRetry})
+	if err != nil {
+		t.Fatalf("attach failed: %s", err)
+	}
+	defer sink.Close()
+
+	h.EmitTimeout(time.Second, "topic", "hello")
+
+	var first string
+	for n := range 3 {
+		select {
+		case id := <-ids:
+			if n == 0 {
+				first = id
+			} else if id != first {
+				t.Errorf("retry used a different id: %s != %s", id, first)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("attempt %d not received", n+1)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	h := emitter.New()
+	defer h.Close()
+	dlq := h.OnWithCap("failed", 1)
+	sink, err := webhook.Attach(h, "topic", srv.URL, webhook.Options{MaxAttempts: 3, Backoff: fastRetry, DeadLetter: "failed"})
+	if err != nil {
+		t.Fatalf("attach failed: %s", err)
+	}
+	defer sink.Close()
+
+	h.Emit(context.Background(), "topic", "lost")
+
+	select {
+	case ev := <-dlq:
+		if ev.Arg(0) != "lost" || ev.Header[webhook.HeaderAttempts] != "3" || ev.Header[webhook.HeaderError] != "webhook: unexpected status 500" {
+			t.Errorf("unexpected dead letter %v %v", ev.Args, ev.Header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event was not dead-lettered")
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestPermanentFailure(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	h := emitter.New()
+	defer h.Close()
+	dlq := h.OnWithCap("failed", 1)
+	sink, _ := webhook.Attach(h, "topic", srv.URL, webhook.Options{Backoff: fastRetry, DeadLetter: "failed"})
+	defer sink.Close()
+
+	h.Emit(context.Background(), "topic", "invalid")
+
+	select {
+	case ev := <-dlq:
+		if ev.Header[webhook.HeaderAttempts] != "1" {
+			t.Errorf("client errors should not be retried, got %s attempts", ev.Header[webhook.HeaderAttempts])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event was not dead-lettered")
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	var lk sync.Mutex
+	var current, peak int
+	var delivered sync.WaitGroup
+	delivered.Add(10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lk.Lock()
+		current += 1
+		peak = max(peak, current)
+		lk.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		lk.Lock()
+		current -= 1
+		lk.Unlock()
+		delivered.Done()
+	}))
+	defer srv.Close()
+
+	h := emitter.New()
+	defer h.Close()
+	sink, _ := webhook.Attach(h, "topic", srv.URL, webhook.Options{Concurrency: 2})
+	defer sink.Close()
+
+	for n := range 10 {
+		h.Emit(context.Background(), "topic", n)
+	}
+	delivered.Wait()
+
+	if peak != 2 {
+		t.Errorf("expected at most 2 concurrent requests, got a peak of %d", peak)
+	}
+}
@@ -0,0 +1,373 @@
+package emitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/KarpelesLab/emitter/internal/ws"
+)
+
+const (
+	// DefaultWebSocketQueue is the default number of outgoing messages buffered for each
+	// connection of [WebSocketHandler].
+	DefaultWebSocketQueue = 256
+
+	// DefaultWebSocketSubscriptions is the default maximum number of subscriptions of
+	// each connection of [WebSocketHandler].
+	DefaultWebSocketSubscriptions = 64
+
+	// DefaultWebSocketEmitTimeout is the default timeout of emits requested by clients of
+	// [WebSocketHandler] that did not specify one.
+	DefaultWebSocketEmitTimeout = 30 * time.Second
+)
+
+var (
+	errWSForbidden     = errors.New("forbidden")
+	errWSTooManySubs   = errors.New("too many subscriptions")
+	errWSAlreadySubbed = errors.New("already subscribed")
+	errWSNotSubbed     = errors.New("not subscribed")
+	errWSClosed        = errors.New("connection closed")
+	errWSNoAuthorize   = errors.New("websocket handler has no Authorize function")
+)
+
+// WebSocketOptions configures a handler created with [WebSocketHandler].
+type WebSocketOptions struct {
+	// Authorize is called with the upgrade request and the topic before each sub, emit
+	// and push, and the operation is refused if it returns false. It is responsible for
+	// checking the request origin, and all requests are refused if it is nil.
+	Authorize func(r *http.Request, topic string) bool
+
+	// MaxSubscriptions is the maximum number of subscriptions of a connection, and
+	// defaults to [DefaultWebSocketSubscriptions].
+	MaxSubscriptions int
+
+	// QueueSize is the number of outgoing messages buffered for each connection, and
+	// defaults to [DefaultWebSocketQueue].
+	QueueSize int
+
+	// DisconnectSlow, if set, disconnects clients whose queue is full. By default, events
+	// that do not fit in the queue are dropped.
+	DisconnectSlow bool
+
+	// EmitTimeout is the timeout of emits that do not specify one, and defaults to
+	// [DefaultWebSocketEmitTimeout].
+	EmitTimeout time.Duration
+}
+
+// WebSocketServer is the [http.Handler] returned by [WebSocketHandler].
+type WebSocketServer struct {
+	hub    *Hub
+	opts   WebSocketOptions
+	lk     sync.Mutex
+	conns  map[*wsConn]bool
+	closed bool
+}
+
+// wsRequest is a message received from a client
+type wsRequest struct {
+	Type    string            `json:"type"`
+	ID      uint64            `json:"id,omitempty"`
+	Topic   string            `json:"topic,omitempty"`
+	Args    []any             `json:"args,omitempty"`
+	Header  map[string]string `json:"header,omitempty"`
+	Timeout int64             `json:"timeout,omitempty"` // in milliseconds
+}
+
+// wsAck acknowledges a request
+type wsAck struct {
+	Type  string `json:"type"`
+	ID    uint64 `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// wsEvent is an event sent to a client
+type wsEvent struct {
+	Type   string            `json:"type"`
+	Sub    string            `json:"sub"` // topic or pattern of the subscription
+	Topic  string            `json:"topic"`
+	Args   []json.RawMessage `json:"args"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type wsConn struct {
+	srv   *WebSocketServer
+	r     *http.Request
+	c     *ws.Conn
+	queue chan []byte
+	done  chan struct{}
+	once  sync.Once
+	subs  map[string]<-chan *Event
+	subLk sync.Mutex
+}
+
+// WebSocketHandler returns an [http.Handler] exposing the hub to browsers over WebSocket,
+// with a small JSON protocol. Clients send requests such as:
+//
+//	{"type": "sub", "id": 1, "topic": "chat/{room}"}
+//	{"type": "unsub", "id": 2, "topic": "chat/{room}"}
+//	{"type": "emit", "id": 3, "topic": "chat/lobby", "args": ["hello"], "timeout": 5000}
+//	{"type": "push", "id": 4, "topic": "refresh"}
+//
+// Each request is answered with an ack, including an error message if it failed:
+//
+//	{"type": "ack", "id": 3, "error": "no such topic"}
+//
+// Events are sent with the subscription they were received through:
+//
+//	{"type": "event", "sub": "chat/{room}", "topic": "chat/lobby", "args": ["hello"]}
+//
+// Events are queued during emit without blocking. When the queue of a slow client is full,
+// events are dropped, or the client is disconnected if [WebSocketOptions.DisconnectSlow]
+// is set.
+func WebSocketHandler(h *Hub, opts WebSocketOptions) *WebSocketServer {
+	return &WebSocketServer{
+		hub:   h,
+		opts:  opts,
+		conns: make(map[*wsConn]bool),
+	}
+}
+
+// ServeHTTP implements [http.Handler].
+func (s *WebSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Authorize == nil {
+		http.Error(w, errWSNoAuthorize.Error(), http.StatusInternalServerError)
+		return
+	}
+	c, err := ws.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	queueSize := s.opts.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultWebSocketQueue
+	}
+	wc := &wsConn{
+		srv:   s,
+		r:     r,
+		c:     c,
+		queue: make(chan []byte, queueSize),
+		done:  make(chan struct{}),
+		subs:  make(map[string]<-chan *Event),
+	}
+
+	s.lk.Lock()
+	if s.closed {
+		s.lk.Unlock()
+		c.Close()
+		return
+	}
+	s.conns[wc] = true
+	s.lk.Unlock()
+
+	go wc.writer()
+	wc.run()
+
+	s.lk.Lock()
+	delete(s.conns, wc)
+	s.lk.Unlock()
+}
+
+// Close disconnects all clients.
+func (s *WebSocketServer) Close() error {
+	s.lk.Lock()
+	s.closed = true
+	conns := s.conns
+	s.conns = make(map[*wsConn]bool)
+	s.lk.Unlock()
+
+	for wc := range conns {
+		wc.close()
+	}
+	return nil
+}
+
+func (wc *wsConn) run() {
+	defer wc.close()
+
+	for {
+		op, data, err := wc.c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if op != ws.OpText {
+			continue
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return
+		}
+		if !wc.ack(req.ID, wc.handle(&req)) {
+			return
+		}
+	}
+}
+
+func (wc *wsConn) handle(req *wsRequest) error {
+	if req.Type != "unsub" && !wc.srv.opts.Authorize(wc.r, req.Topic) {
+		return errWSForbidden
+	}
+
+	switch req.Type {
+	case "sub":
+		return wc.subscribe(req.Topic)
+	case "unsub":
+		return wc.unsubscribe(req.Topic)
+	case "emit":
+		return wc.emit(req)
+	case "push":
+		wc.srv.hub.Push(req.Topic)
+		return nil
+	default:
+		return errors.New("unsupported message type")
+	}
+}
+
+// ack queues an acknowledgement, waiting for room in the queue. It returns false if the
+// connection was closed meanwhile.
+func (wc *wsConn) ack(id uint64, err error) bool {
+	msg := &wsAck{Type: "ack", ID: id}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	buf, _ := json.Marshal(msg)
+
+	select {
+	case wc.queue <- buf:
+		return true
+	case <-wc.done:
+		return false
+	}
+}
+
+func (wc *wsConn) subscribe(topic string) error {
+	wc.subLk.Lock()
+	defer wc.subLk.Unlock()
+
+	if wc.subs == nil {
+		return errWSClosed
+	}
+	if _, ok := wc.subs[topic]; ok {
+		return errWSAlreadySubbed
+	}
+	limit := wc.srv.opts.MaxSubscriptions
+	if limit <= 0 {
+		limit = DefaultWebSocketSubscriptions
+	}
+	if len(wc.subs) >= limit {
+		return errWSTooManySubs
+	}
+
+	l := newListener(0)
+	ch := l.ch
+	l.push = func(ctx context.Context, ev *Event) error {
+		wc.deliver(topic, ev)
+		return nil
+	}
+	if err := wc.srv.hub.attach(topic, l, ch); err != nil {
+		return err
+	}
+	wc.subs[topic] = ch
+	return nil
+}
+
+func (wc *wsConn) unsubscribe(topic string) error {
+	wc.subLk.Lock()
+	ch, ok := wc.subs[topic]
+	delete(wc.subs, topic)
+	wc.subLk.Unlock()
+
+	if !ok {
+		return errWSNotSubbed
+	}
+	wc.srv.hub.Unsubscribe(ch)
+	return nil
+}
+
+// deliver queues ev without blocking, it is called during emit
+func (wc *wsConn) deliver(sub string, ev *Event) {
+	msg := &wsEvent{
+		Type:   "event",
+		Sub:    sub,
+		Topic:  wc.srv.hub.relative(ev.Topic),
+		Args:   make([]json.RawMessage, len(ev.Args)),
+		Header: ev.Header,
+	}
+	for n := range ev.Args {
+		buf, err := ev.EncodedArg(uint(n), "json", json.Marshal)
+		if err != nil {
+			wc.srv.hub.root().reportError(err)
+			return
+		}
+		msg.Args[n] = buf
+	}
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		wc.srv.hub.root().reportError(err)
+		return
+	}
+
+	select {
+	case wc.queue <- buf:
+	case <-wc.done:
+	default:
+		// slow client, the event is dropped
+		if wc.srv.opts.DisconnectSlow {
+			go wc.close()
+		}
+	}
+}
+
+func (wc *wsConn) emit(req *wsRequest) error {
+	timeout := wc.srv.opts.EmitTimeout
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Millisecond
+	} else if timeout <= 0 {
+		timeout = DefaultWebSocketEmitTimeout
+	}
+	ctx, cancel := context.WithTimeout(wc.r.Context(), timeout)
+	defer cancel()
+
+	ev := &Event{
+		Context: ctx,
+		Args:    req.Args,
+		Header:  req.Header,
+	}
+	return wc.srv.hub.EmitEvent(ctx, req.Topic, ev)
+}
+
+// writer sends queued messages until the connection is closed
+func (wc *wsConn) writer() {
+	for {
+		select {
+		case buf := <-wc.queue:
+			if err := wc.c.WriteMessage(ws.OpText, buf); err != nil {
+				wc.close()
+				return
+			}
+		case <-wc.done:
+			return
+		}
+	}
+}
+
+func (wc *wsConn) close() {
+	wc.once.Do(func() {
+		close(wc.done)
+		wc.c.Close()
+
+		wc.subLk.Lock()
+		subs := wc.subs
+		wc.subs = nil
+		wc.subLk.Unlock()
+
+		for _, ch := range subs {
+			wc.srv.hub.Unsubscribe(ch)
+		}
+	})
+}
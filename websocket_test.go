@@ -0,0 +1,217 @@
+package emitter_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/emitter"
+	"github.com/KarpelesLab/emitter/internal/ws"
+)
+
+type wsTestClient struct {
+	t       *testing.T
+	c       *ws.Conn
+	id      uint64
+	pending []map[string]any // messages received while waiting for an ack
+}
+
+func wsConnect(t *testing.T, srv *httptest.Server) *wsTestClient {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := ws.Dial(ctx, "ws://"+strings.TrimPrefix(srv.URL, "http://")+"/", "", nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &wsTestClient{t: t, c: c}
+}
+
+func (tc *wsTestClient) read() map[string]any {
+	if len(tc.pending) > 0 {
+		msg := tc.pending[0]
+		tc.pending = tc.pending[1:]
+		return msg
+	}
+	return tc.readConn()
+}
+
+func (tc *wsTestClient) readConn() map[string]any {
+	tc.c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := tc.c.ReadMessage()
+	if err != nil {
+		tc.t.Fatalf("failed to read: %s", err)
+	}
+	var res map[string]any
+	if err := json.Unmarshal(data, &res); err != nil {
+		tc.t.Fatalf("invalid message %s", data)
+	}
+	return res
+}
+
+// request sends a request and returns the error of its ack
+func (tc *wsTestClient) request(req map[string]any) string {
+	tc.id += 1
+	req["id"] = tc.id
+	buf, _ := json.Marshal(req)
+	if err := tc.c.WriteMessage(ws.OpText, buf); err != nil {
+		tc.t.Fatalf("failed to write: %s", err)
+	}
+	for {
+		msg := tc.readConn()
+		if msg["type"] == "ack" && msg["id"] == float64(tc.id) {
+			err, _ := msg["error"].(string)
+			return err
+		}
+		tc.pending = append(tc.pending, msg)
+	}
+}
+
+func allowAll(r *http.Request, topic string) bool { return true }
+
+func TestWebSocket(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	// requests are refused until operations are authorized by a callback
+	rec := httptest.NewRecorder()
+	emitter.WebSocketHandler(h, emitter.WebSocketOptions{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected request to be refused, got %d", rec.Code)
+	}
+
+	gw := emitter.WebSocketHandler(h, emitter.WebSocketOptions{Authorize: allowAll})
+	defer gw.Close()
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	tc := wsConnect(t, srv)
+	if err := tc.request(map[string]any{"type": "sub", "topic": "chat/{room}"}); err != "" {
+		t.Fatalf("sub failed: %s", err)
+	}
+
+	// emit from the hub
+	go h.EmitTimeout(time.Second, "chat/lobby", "hello", 42)
+	msg := tc.read()
+	if msg["type"] != "event" || msg["sub"] != "chat/{room}" || msg["topic"] != "chat/lobby" {
+		t.Errorf("unexpected event %v", msg)
+	}
+	if args, _ := msg["args"].([]any); len(args) != 2 || args[0] != "hello" || args[1] != float64(42) {
+		t.Errorf("unexpected args %v", msg["args"])
+	}
+
+	// emit from the client, received by a local listener and the client itself
+	ch := h.OnWithCap("chat/kitchen", 1)
+	if err := tc.request(map[string]any{"type": "emit", "topic": "chat/kitchen", "args": []any{"cmd"}}); err != "" {
+		t.Fatalf("emit failed: %s", err)
+	}
+	select {
+	case ev := <-ch:
+		if ev.Arg(0) != "cmd" {
+			t.Errorf("unexpected argument %v", ev.Arg(0))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event not received by local listener")
+	}
+	if msg = tc.read(); msg["topic"] != "chat/kitchen" {
+		t.Errorf("unexpected event %v", msg)
+	}
+
+	if err := tc.request(map[string]any{"type": "emit", "topic": "nobody"}); err != emitter.ErrNoSuchTopic.Error() {
+		t.Errorf("expected no such topic, got %q", err)
+	}
+
+	// push
+	trig := h.Trigger("refresh").ListenCap(1)
+	defer trig.Release()
+	if err := tc.request(map[string]any{"type": "push", "topic": "refresh"}); err != "" {
+		t.Fatalf("push failed: %s", err)
+	}
+	select {
+	case <-trig.C:
+	case <-time.After(time.Second):
+		t.Errorf("trigger was not pushed")
+	}
+
+	// unsub
+	if err := tc.request(map[string]any{"type": "unsub", "topic": "chat/{room}"}); err != "" {
+		t.Fatalf("unsub failed: %s", err)
+	}
+	if err := tc.request(map[string]any{"type": "unsub", "topic": "chat/{room}"}); err == "" {
+		t.Errorf("expected second unsub to fail")
+	}
+}
+
+func TestWebSocketLimits(t *testing.T) {
+	h := emitter.New()
+	defer h.Close()
+	gw := emitter.WebSocketHandler(h, emitter.WebSocketOptions{
+		MaxSubscriptions: 2,
+		Authorize: func(r *http.Request, topic string) bool {
+			return !strings.HasPrefix(topic, "admin/")
+		},
+	})
+	defer gw.Close()
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	tc := wsConnect(t, srv)
+	if err := tc.request(map[string]any{"type": "sub", "topic": "admin/logs"}); err != "forbidden" {
+		t.Errorf("expected sub to be forbidden, got %q", err)
+	}
+	if err := tc.request(map[string]any{"type": "emit", "topic": "admin/shutdown"}); err != "forbidden" {
+		t.Errorf("expected emit to be forbidden, got %q", err)
+	}
+
+	for _, topic := range []string{"a", "b"} {
+		if err := tc.request(map[string]any{"type": "sub", "topic": topic}); err != "" {
+			t.Fatalf("sub failed: %s", err)
+		}
+	}
+	if err := tc.request(map[string]any{"type": "sub", "topic": "c"}); err != "too many subscriptions" {
+		t.Errorf("expected subscription limit, got %q", err)
+	}
+}
+
+func TestWebSocketSlowClient(t *testing.T) {
+	for _, disconnect := range []bool{false, true} {
+		h := emitter.New()
+		gw := emitter.WebSocketHandler(h, emitter.WebSocketOptions{Authorize: allowAll, QueueSize: 4, DisconnectSlow: disconnect})
+		srv := httptest.NewServer(gw)
+
+		tc := wsConnect(t, srv)
+		if err := tc.request(map[string]any{"type": "sub", "topic": "feed"}); err != "" {
+			t.Fatalf("sub failed: %s", err)
+		}
+
+		// the client does not read, emits must not block once socket buffers are full
+		payload := strings.Repeat("x", 64*1024)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		for range 500 {
+			if err := h.Emit(ctx, "feed", payload); err != nil && err != emitter.ErrNoSuchTopic {
+				t.Fatalf("emit failed: %s", err)
+			}
+		}
+		cancel()
+
+		if disconnect {
+			// the connection ends once buffered messages are read
+			tc.c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for {
+				if _, _, err := tc.c.ReadMessage(); err != nil {
+					if strings.Contains(err.Error(), "timeout") {
+						t.Errorf("slow client was not disconnected")
+					}
+					break
+				}
+			}
+		}
+
+		gw.Close()
+		srv.Close()
+		h.Close()
+	}
+}